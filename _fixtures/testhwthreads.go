@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+func work(n int) int {
+	return n * 2
+}
+
+func main() {
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			// Make sure every goroutine runs on its own thread.
+			runtime.LockOSThread()
+			for j := 0; j < 100; j++ {
+				fmt.Println(work(n))
+			}
+		}(i)
+	}
+	wg.Wait()
+}
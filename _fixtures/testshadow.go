@@ -0,0 +1,16 @@
+package main
+
+import "fmt"
+
+func main() {
+	a := 1
+	b := 2
+	for i := 0; i < 3; i++ {
+		a := i * 10
+		if i == 2 {
+			b := "inner"
+			fmt.Println(a, b, i)
+		}
+	}
+	fmt.Println(a, b)
+}
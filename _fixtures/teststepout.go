@@ -0,0 +1,20 @@
+package main
+
+import "fmt"
+
+func sum(a, b int) int {
+	return a + b
+}
+
+func recurse(n int) int {
+	if n == 0 {
+		return 0
+	}
+	return n + recurse(n-1)
+}
+
+func main() {
+	s := sum(1, 2)
+	r := recurse(3)
+	fmt.Println(s, r)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+func main() {
+	m1 := map[string]int{"one": 1}
+	m2 := make(map[int]string)
+	for i := 0; i < 100; i++ {
+		m2[i] = strconv.Itoa(i)
+	}
+	var m3 map[string]int
+	var err1 error = &os.PathError{Op: "open", Path: "/nonexistent", Err: syscall.ENOENT}
+	var err2 error
+	var iface1 interface{} = 5
+	ch1 := make(chan int, 3)
+	ch1 <- 1
+	ch1 <- 2
+	ch1 <- 3
+	<-ch1
+	ch1 <- 4
+	ch2 := make(chan string)
+	go func() { ch2 <- "blocked" }()
+	var ch3 chan int
+	var c64 complex64 = 1 + 2i
+	var c128 complex128 = 3.5 - 4i
+	var r1 rune = 'x'
+	bs := []byte("hello")
+	var up2 uintptr = 0xdead
+	unp := unsafe.Pointer(&m1)
+	count := 0
+	prefix := "mw"
+	fn1 := func(x int) int { count++; return x + len(prefix) }
+	fn2 := func(x int) int { return fn1(x) * 2 }
+	time.Sleep(100 * time.Millisecond)
+	fmt.Println(m1, m2, m3, err1, err2, iface1, ch1, ch2, ch3, c64, c128, r1, bs, up2, unp, fn1(1), fn2(2), config.Name, posInf, negInf, nan)
+}
+
+type Config struct {
+	Name    string
+	Retries int
+}
+
+var config = Config{Name: "default", Retries: 3}
+
+var (
+	zero   float64
+	posInf = 1 / zero
+	negInf = -1 / zero
+	nan    = zero / zero
+)
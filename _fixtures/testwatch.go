@@ -0,0 +1,15 @@
+package main
+
+import "fmt"
+
+type counter struct {
+	count int
+}
+
+func main() {
+	var c counter
+	for i := 0; i < 3; i++ {
+		c.count += 2
+	}
+	fmt.Println(c.count)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+var total int
+
+func main() {
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	for i := 1; i <= 4; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			// Make sure every goroutine runs on its own thread.
+			runtime.LockOSThread()
+			mu.Lock()
+			total += n
+			mu.Unlock()
+		}(i)
+	}
+	wg.Wait()
+	fmt.Println(total)
+}
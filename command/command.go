@@ -4,9 +4,16 @@ package command
 
 import (
 	"bufio"
+	"bytes"
+	"debug/gosym"
+	"encoding/binary"
 	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/parser"
 	"io"
 	"os"
+	"reflect"
 	"regexp"
 	"sort"
 	"strconv"
@@ -36,25 +43,42 @@ func (c command) match(cmdstr string) bool {
 type Commands struct {
 	cmds    []command
 	lastCmd cmdfunc
+
+	// Limits used to load the values of variables.
+	loadConfig proctl.LoadConfig
 }
 
 // Returns a Commands struct with default commands defined.
 func DebugCommands() *Commands {
-	c := &Commands{}
+	c := &Commands{loadConfig: proctl.DefaultLoadConfig}
 
 	c.cmds = []command{
 		command{aliases: []string{"help"}, cmdFn: c.help, helpMsg: "Prints the help message."},
-		command{aliases: []string{"break", "b"}, cmdFn: breakpoint, helpMsg: "Set break point at the entry point of a function, or at a specific file/line. Example: break foo.go:13"},
+		command{aliases: []string{"break", "b"}, cmdFn: breakpoint, helpMsg: "Set break point at the entry point of a function, or at a specific file/line. Optionally stop only in one goroutine, or only if a condition holds. Example: break foo.go:13 goroutine 17 if i == 10"},
 		command{aliases: []string{"continue", "c"}, cmdFn: cont, helpMsg: "Run until breakpoint or program termination."},
 		command{aliases: []string{"step", "si"}, cmdFn: step, helpMsg: "Single step through program."},
 		command{aliases: []string{"next", "n"}, cmdFn: next, helpMsg: "Step over to next source line."},
+		command{aliases: []string{"stepout", "finish"}, cmdFn: stepout, helpMsg: "Run until the current function returns, then print its return values."},
 		command{aliases: []string{"threads"}, cmdFn: threads, helpMsg: "Print out info for every traced thread."},
 		command{aliases: []string{"thread", "t"}, cmdFn: thread, helpMsg: "Switch to the specified thread."},
 		command{aliases: []string{"clear"}, cmdFn: clear, helpMsg: "Deletes breakpoint."},
 		command{aliases: []string{"goroutines"}, cmdFn: goroutines, helpMsg: "Print out info for every goroutine."},
+		command{aliases: []string{"goroutine"}, cmdFn: goroutine, helpMsg: "Select the goroutine used to print the stack and evaluate variables. Example: goroutine 17"},
+		command{aliases: []string{"stack", "bt"}, cmdFn: stack, helpMsg: "Print stack trace of the selected goroutine. Optionally specify the maximum depth: stack 20"},
+		command{aliases: []string{"frame"}, cmdFn: frame, helpMsg: "Select the stack frame used to evaluate variables. Example: frame 2"},
+		command{aliases: []string{"up"}, cmdFn: up, helpMsg: "Move up the stack towards the caller, optionally by n frames."},
+		command{aliases: []string{"down"}, cmdFn: down, helpMsg: "Move down the stack towards the innermost frame, optionally by n frames."},
 		command{aliases: []string{"breakpoints", "bp"}, cmdFn: breakpoints, helpMsg: "Print out info for active breakpoints."},
-		command{aliases: []string{"print", "p"}, cmdFn: printVar, helpMsg: "Evaluate a variable."},
-		command{aliases: []string{"info"}, cmdFn: info, helpMsg: "Provides info about args, funcs, locals, sources, or vars."},
+		command{aliases: []string{"watch"}, cmdFn: watchpoint(proctl.WatchWrite), helpMsg: "Stop when a variable is written to. Example: watch obj.count"},
+		command{aliases: []string{"rwatch"}, cmdFn: watchpoint(proctl.WatchRead), helpMsg: "Stop when a variable is read."},
+		command{aliases: []string{"awatch"}, cmdFn: watchpoint(proctl.WatchReadWrite), helpMsg: "Stop when a variable is read or written to."},
+		command{aliases: []string{"trace"}, cmdFn: tracepoint, helpMsg: "Set tracepoint: trace <location> [var ...]. Logs the given variables, or the function arguments, each time the location is hit without stopping."},
+		command{aliases: []string{"condition", "cond"}, cmdFn: condition, helpMsg: "Set or clear the condition of a breakpoint: condition <id> [expr]. With -hitcount, stop based on the hit count instead: condition -hitcount <id> [<op> <n>], op being one of ==, !=, <, <=, >, >= or %."},
+		command{aliases: []string{"print", "p"}, cmdFn: c.printVar, helpMsg: "Evaluate an expression. Options override the limits set with config for a single print: print [-<option> <value> ...] <expr>. Example: print -max-array 100 s[1000:1100]. A format modifier prints integers in hexadecimal (-x), octal (-o), binary (-b), as characters (-c), or prints the raw bytes of values (-r); p/x <expr> is short for print -x <expr>."},
+		command{aliases: []string{"set"}, cmdFn: setVar, helpMsg: "Change the value of a variable. Example: set obj.count = 10"},
+		command{aliases: []string{"x"}, cmdFn: examineMemory, helpMsg: "Examine memory: x <addr|expr> [count] [format]. Formats are x (hex bytes, the default), w (hex words), a (ASCII) and i (instructions); count is the number of bytes, words, characters or instructions. Pointers and integers are used as addresses, other values are examined where they are stored. Example: x &buf[0] 32"},
+		command{aliases: []string{"info"}, cmdFn: c.info, helpMsg: "Provides info about args, funcs, locals, sources, or vars."},
+		command{aliases: []string{"config"}, cmdFn: c.config, helpMsg: "Print or change the limits used to load variables: config [<option> <value>]. Options are max-recurse, max-array, max-string, follow-pointers and hex-bytes."},
 		command{aliases: []string{"exit"}, cmdFn: nullCommand, helpMsg: "Exit the debugger."},
 	}
 
@@ -93,6 +117,20 @@ func (c *Commands) Find(cmdstr string) cmdfunc {
 		}
 	}
 
+	// p/x <expr> is print with a format modifier.
+	if i := strings.IndexByte(cmdstr, '/'); i > 0 && (cmdstr[:i] == "print" || cmdstr[:i] == "p") {
+		name := cmdstr[i+1:]
+		if _, ok := formatModifier(name); !ok {
+			return func(p *proctl.DebuggedProcess, args ...string) error {
+				return fmt.Errorf("unknown format modifier %s", name)
+			}
+		}
+		c.lastCmd = func(p *proctl.DebuggedProcess, args ...string) error {
+			return c.printVar(p, append([]string{"-" + name}, args...)...)
+		}
+		return c.lastCmd
+	}
+
 	return noCmdAvailable
 }
 
@@ -163,18 +201,131 @@ func goroutines(p *proctl.DebuggedProcess, args ...string) error {
 	if err != nil {
 		return err
 	}
+	// Not every thread runs a goroutine, in which case none is selected.
+	selected := 0
+	if g, err := p.SelectedGoroutine(); err == nil {
+		selected = g.Id
+	}
 
 	fmt.Printf("[%d goroutines]\n", len(gs))
 	for _, g := range gs {
+		prefix := "  "
+		if g.Id == selected {
+			prefix = "* "
+		}
 		if g.Func != nil {
 			fname = g.Func.Name
 		}
-		fmt.Printf("Goroutine %d - %s:%d %s\n", g.Id, g.File, g.Line, fname)
+		fmt.Printf("%sGoroutine %d - %s:%d %s\n", prefix, g.Id, g.File, g.Line, fname)
+	}
+
+	return nil
+}
+
+func goroutine(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("you must specify a goroutine")
+	}
+	gid, err := strconv.Atoi(args[0])
+	if err != nil {
+		return err
+	}
+
+	if err := p.SwitchGoroutine(gid); err != nil {
+		return err
+	}
+	frames, err := p.Stacktrace(1)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Switched to goroutine %d\n", gid)
+	return printfile(frames[0].Fn, frames[0].File, frames[0].Line)
+}
+
+func stack(p *proctl.DebuggedProcess, args ...string) error {
+	var (
+		depth = 10
+		err   error
+	)
+	if len(args) > 0 {
+		if depth, err = strconv.Atoi(args[0]); err != nil {
+			return fmt.Errorf("invalid depth %s", args[0])
+		}
+	}
+
+	frames, err := p.Stacktrace(depth)
+	if err != nil {
+		return err
+	}
+
+	for i, frame := range frames {
+		name := "?"
+		if frame.Fn != nil {
+			name = frame.Fn.Name
+		}
+		prefix := "  "
+		if i == p.CurrentThread.SelectedFrame() {
+			prefix = "* "
+		}
+		fmt.Printf("%s%d  %#v in %s\n\tat %s:%d\n", prefix, i, frame.PC, name, frame.File, frame.Line)
 	}
 
 	return nil
 }
 
+func frame(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("you must specify a frame")
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid frame %s", args[0])
+	}
+	return selectFrame(p, n)
+}
+
+func up(p *proctl.DebuggedProcess, args ...string) error {
+	n, err := frameOffset(args)
+	if err != nil {
+		return err
+	}
+	return selectFrame(p, p.CurrentThread.SelectedFrame()+n)
+}
+
+func down(p *proctl.DebuggedProcess, args ...string) error {
+	n, err := frameOffset(args)
+	if err != nil {
+		return err
+	}
+	return selectFrame(p, p.CurrentThread.SelectedFrame()-n)
+}
+
+// Parses the optional frame count given to up and down.
+func frameOffset(args []string) (int, error) {
+	if len(args) == 0 {
+		return 1, nil
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		return 0, fmt.Errorf("invalid frame count %s", args[0])
+	}
+	return n, nil
+}
+
+func selectFrame(p *proctl.DebuggedProcess, n int) error {
+	if err := p.CurrentThread.SelectFrame(n); err != nil {
+		return err
+	}
+	frames, err := p.Stacktrace(n + 1)
+	if err != nil {
+		return err
+	}
+	f := frames[n]
+	fmt.Printf("Frame %d: %#v\n", n, f.PC)
+	return printfile(f.Fn, f.File, f.Line)
+}
+
 func cont(p *proctl.DebuggedProcess, args ...string) error {
 	err := p.Continue()
 	if err != nil {
@@ -202,6 +353,19 @@ func next(p *proctl.DebuggedProcess, args ...string) error {
 	return printcontext(p)
 }
 
+func stepout(p *proctl.DebuggedProcess, args ...string) error {
+	retvals, err := p.StepOut()
+	if err != nil {
+		return err
+	}
+
+	for _, v := range retvals {
+		fmt.Printf("%s = %s\n", v.Name, v.Value)
+	}
+
+	return printcontext(p)
+}
+
 func clear(p *proctl.DebuggedProcess, args ...string) error {
 	if len(args) == 0 {
 		return fmt.Errorf("not enough arguments")
@@ -243,12 +407,91 @@ func breakpoints(p *proctl.DebuggedProcess, args ...string) error {
 	sort.Sort(ById(bps))
 	for _, bp := range bps {
 		fmt.Println(bp)
+		fmt.Printf("\thit count: %d%s\n", bp.TotalHitCount, goroutineHitCounts(bp))
 	}
 
 	return nil
 }
 
+// Formats the per goroutine hit counts of a breakpoint, ordered by goroutine.
+func goroutineHitCounts(bp *proctl.BreakPoint) string {
+	if len(bp.HitCount) == 0 {
+		return ""
+	}
+	ids := make([]int, 0, len(bp.HitCount))
+	for id := range bp.HitCount {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	counts := make([]string, 0, len(ids))
+	for _, id := range ids {
+		counts = append(counts, fmt.Sprintf("goroutine %d: %d", id, bp.HitCount[id]))
+	}
+	return " (" + strings.Join(counts, ", ") + ")"
+}
+
+func condition(p *proctl.DebuggedProcess, args ...string) error {
+	hitcount := len(args) > 0 && args[0] == "-hitcount"
+	if hitcount {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("not enough arguments")
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid breakpoint id %s", args[0])
+	}
+	bp, ok := p.FindBreakpointByID(id)
+	if !ok {
+		return fmt.Errorf("no breakpoint with id %d", id)
+	}
+
+	switch {
+	case hitcount && len(args) == 1:
+		bp.HitCond = nil
+	case hitcount:
+		if len(args) != 3 {
+			return fmt.Errorf("usage: condition -hitcount <id> <operator> <count>")
+		}
+		hc, err := proctl.ParseHitCondition(args[1], args[2])
+		if err != nil {
+			return err
+		}
+		bp.HitCond = hc
+	case len(args) == 1:
+		bp.Cond = nil
+	default:
+		cond, err := p.ParseExpr(strings.Join(args[1:], " "))
+		if err != nil {
+			return fmt.Errorf("invalid condition: %s", err)
+		}
+		bp.Cond = cond
+	}
+
+	fmt.Println(bp)
+	return nil
+}
+
 func breakpoint(p *proctl.DebuggedProcess, args ...string) error {
+	loc, gid, cond, err := parseBreakpointArgs(args, p.ParseExpr)
+	if err != nil {
+		return err
+	}
+
+	bp, err := p.BreakByLocation(loc)
+	if err != nil {
+		return err
+	}
+	bp.GoroutineID = gid
+	bp.Cond = cond
+
+	fmt.Printf("Breakpoint %d set at %#v for %s %s:%d\n", bp.ID, bp.Addr, bp.FunctionName, bp.File, bp.Line)
+
+	return nil
+}
+
+func tracepoint(p *proctl.DebuggedProcess, args ...string) error {
 	if len(args) == 0 {
 		return fmt.Errorf("not enough arguments")
 	}
@@ -257,18 +500,78 @@ func breakpoint(p *proctl.DebuggedProcess, args ...string) error {
 	if err != nil {
 		return err
 	}
+	bp.Tracepoint = true
+	bp.Variables = args[1:]
 
-	fmt.Printf("Breakpoint %d set at %#v for %s %s:%d\n", bp.ID, bp.Addr, bp.FunctionName, bp.File, bp.Line)
+	fmt.Printf("Tracepoint %d set at %#v for %s %s:%d\n", bp.ID, bp.Addr, bp.FunctionName, bp.File, bp.Line)
 
 	return nil
 }
 
-func printVar(p *proctl.DebuggedProcess, args ...string) error {
+func watchpoint(wtype proctl.WatchType) cmdfunc {
+	return func(p *proctl.DebuggedProcess, args ...string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("usage: %s <expr>", wtype)
+		}
+
+		bp, err := p.Watch(args[0], wtype)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Watchpoint %d set at %#v for %s (%s)\n", bp.ID, bp.Addr, bp.WatchExpr, bp.NewValue)
+
+		return nil
+	}
+}
+
+const breakpointUsage = "usage: break <location> [goroutine <id>] [if <condition>]"
+
+// Parses the arguments of the break command, which have the form
+// `<location> [goroutine <id>] [if <condition>]`, the condition with
+// parseExpr. A goroutine ID of 0 means the breakpoint applies to every
+// goroutine.
+func parseBreakpointArgs(args []string, parseExpr func(string) (ast.Expr, error)) (string, int, ast.Expr, error) {
+	if len(args) == 0 {
+		return "", 0, nil, fmt.Errorf("not enough arguments")
+	}
+	loc, args := args[0], args[1:]
+
+	var gid int
+	if len(args) > 0 && args[0] == "goroutine" {
+		if len(args) < 2 {
+			return "", 0, nil, fmt.Errorf(breakpointUsage)
+		}
+		id, err := strconv.Atoi(args[1])
+		if err != nil || id <= 0 {
+			return "", 0, nil, fmt.Errorf("invalid goroutine id %s", args[1])
+		}
+		gid, args = id, args[2:]
+	}
+
+	if len(args) == 0 {
+		return loc, gid, nil, nil
+	}
+	if args[0] != "if" || len(args) < 2 {
+		return "", 0, nil, fmt.Errorf(breakpointUsage)
+	}
+	cond, err := parseExpr(strings.Join(args[1:], " "))
+	if err != nil {
+		return "", 0, nil, fmt.Errorf("invalid condition: %s", err)
+	}
+	return loc, gid, cond, nil
+}
+
+func (c *Commands) printVar(p *proctl.DebuggedProcess, args ...string) error {
+	cfg, args, err := parsePrintOptions(c.loadConfig, args)
+	if err != nil {
+		return err
+	}
 	if len(args) == 0 {
 		return fmt.Errorf("not enough arguments")
 	}
 
-	val, err := p.EvalSymbol(args[0])
+	val, err := p.EvalExpression(strings.Join(args, " "), cfg)
 	if err != nil {
 		return err
 	}
@@ -277,6 +580,286 @@ func printVar(p *proctl.DebuggedProcess, args ...string) error {
 	return nil
 }
 
+func examineMemory(p *proctl.DebuggedProcess, args ...string) error {
+	expr, count, format, err := parseExamineArgs(args)
+	if err != nil {
+		return err
+	}
+
+	addr, err := examineAddress(p, expr, format)
+	if err != nil {
+		return err
+	}
+
+	if format == 'i' {
+		insts, err := p.Disassemble(addr, count)
+		for _, inst := range insts {
+			fmt.Printf("%#x:\t% x\t%s\n", inst.Addr, inst.Bytes, inst.Text)
+		}
+		return err
+	}
+
+	size := count
+	if format == 'w' {
+		size *= 8
+	}
+	data, err := p.ReadMemory(uintptr(addr), size)
+	if err != nil {
+		return err
+	}
+	fmt.Print(formatMemory(addr, data, format))
+	return nil
+}
+
+// Returns the address examined by x for expr. Integers and pointers are
+// used as the address, other values are examined where they are stored.
+// Function names disassemble from the entry of the function.
+func examineAddress(p *proctl.DebuggedProcess, expr string, format byte) (uint64, error) {
+	if format == 'i' {
+		if fn := p.LookupFunc(expr); fn != nil {
+			return fn.Entry, nil
+		}
+	}
+
+	// Only the address of the value is needed.
+	cfg := proctl.LoadConfig{FollowPointers: false}
+	v, err := p.EvalExpression(expr, cfg)
+	if err != nil {
+		return 0, err
+	}
+	switch v.Kind {
+	case reflect.Ptr, reflect.UnsafePointer, reflect.Uintptr,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		addr, exact := constant.Uint64Val(v.ConstValue)
+		if !exact {
+			return 0, fmt.Errorf("%s is not a valid address", v.Value)
+		}
+		return addr, nil
+	}
+	// Computed values and values assembled from pieces, such as those
+	// held in registers, have no address.
+	if v.Addr == 0 {
+		return 0, fmt.Errorf("%s is not in memory", expr)
+	}
+	return uint64(v.Addr), nil
+}
+
+// Largest count x reads, so that a mistyped count does not make it
+// allocate and read gigabytes of the target.
+const maxExamineCount = 4096
+
+// Splits the arguments of x into the expression, the count and the
+// format, setting the defaults of the omitted ones. The count is only
+// taken from the arguments if the rest still parses as an expression,
+// so that x p + 16 examines p + 16, and is clamped to maxExamineCount.
+func parseExamineArgs(args []string) (string, int, byte, error) {
+	var format byte = 'x'
+	if n := len(args); n > 1 && len(args[n-1]) == 1 && strings.Contains("xwai", args[n-1]) {
+		format = args[n-1][0]
+		args = args[:n-1]
+	}
+
+	count := 64
+	switch format {
+	case 'w':
+		count = 8
+	case 'i':
+		count = 10
+	}
+	if n := len(args); n > 1 {
+		if c, err := strconv.Atoi(args[n-1]); err == nil {
+			if _, err := parser.ParseExpr(strings.Join(args[:n-1], " ")); err == nil {
+				if c <= 0 {
+					return "", 0, 0, fmt.Errorf("invalid count %d", c)
+				}
+				if c > maxExamineCount {
+					c = maxExamineCount
+				}
+				count = c
+				args = args[:n-1]
+			}
+		}
+	}
+
+	if len(args) == 0 {
+		return "", 0, 0, fmt.Errorf("usage: x <addr|expr> [count] [format]")
+	}
+	return strings.Join(args, " "), count, format, nil
+}
+
+// Formats memory read at addr as hex bytes, hex words or ASCII, a line
+// of 16 bytes at a time prefixed by its address.
+func formatMemory(addr uint64, data []byte, format byte) string {
+	var buf bytes.Buffer
+	for off := 0; off < len(data); off += 16 {
+		line := data[off:]
+		if len(line) > 16 {
+			line = line[:16]
+		}
+		fmt.Fprintf(&buf, "%#x:", addr+uint64(off))
+		switch format {
+		case 'w':
+			for i := 0; i+8 <= len(line); i += 8 {
+				fmt.Fprintf(&buf, " %#016x", binary.LittleEndian.Uint64(line[i:]))
+			}
+		case 'a':
+			buf.WriteString(" ")
+			for _, b := range line {
+				if b < ' ' || b > '~' {
+					b = '.'
+				}
+				buf.WriteByte(b)
+			}
+		default:
+			fmt.Fprintf(&buf, " % x", line)
+		}
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+func setVar(p *proctl.DebuggedProcess, args ...string) error {
+	name, value, err := parseAssignment(strings.Join(args, " "))
+	if err != nil {
+		return err
+	}
+	return p.SetVariable(name, value)
+}
+
+// Splits "<variable> = <value>" at the assignment operator, leaving
+// comparison operators and string literals on either side alone.
+func parseAssignment(s string) (string, string, error) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"', '\'', '`':
+			// Skip to the closing quote.
+			quote := s[i]
+			for i++; i < len(s) && s[i] != quote; i++ {
+				if s[i] == '\\' && quote != '`' {
+					i++
+				}
+			}
+			continue
+		case '=':
+		default:
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == '=' {
+			i++
+			continue
+		}
+		if i > 0 && strings.IndexByte("=!<>", s[i-1]) >= 0 {
+			continue
+		}
+		name, value := strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:])
+		if name == "" || value == "" {
+			break
+		}
+		return name, value, nil
+	}
+	return "", "", fmt.Errorf("usage: set <variable> = <value>")
+}
+
+// Consumes the leading "-<option> <value>" pairs and format modifiers of
+// the print command, applying them to cfg. Anything else starts the
+// expression.
+func parsePrintOptions(cfg proctl.LoadConfig, args []string) (proctl.LoadConfig, []string, error) {
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		// A modifier followed by an operator is a negated variable
+		// instead: print -x + 1.
+		if verb, ok := formatModifier(args[0][1:]); ok && len(args) > 1 && strings.Trim(args[1], "+-*/%&|^<>=!") != "" {
+			cfg.Format = verb
+			args = args[1:]
+			continue
+		}
+		if !isLoadOption(args[0][1:]) {
+			break
+		}
+		if len(args) < 2 {
+			return cfg, nil, fmt.Errorf("option %s requires a value", args[0])
+		}
+		if err := setLoadOption(&cfg, args[0][1:], args[1]); err != nil {
+			return cfg, nil, err
+		}
+		args = args[2:]
+	}
+	return cfg, args, nil
+}
+
+func (c *Commands) config(p *proctl.DebuggedProcess, args ...string) error {
+	switch len(args) {
+	case 0:
+		fmt.Printf("max-recurse %d\n", c.loadConfig.MaxVariableRecurse)
+		fmt.Printf("max-array %d\n", c.loadConfig.MaxArrayValues)
+		fmt.Printf("max-string %d\n", c.loadConfig.MaxStringLen)
+		fmt.Printf("follow-pointers %t\n", c.loadConfig.FollowPointers)
+		fmt.Printf("hex-bytes %t\n", c.loadConfig.HexDumpBytes)
+		return nil
+	case 2:
+		return setLoadOption(&c.loadConfig, args[0], args[1])
+	}
+	return fmt.Errorf("usage: config [<option> <value>]")
+}
+
+// Returns the format verb of the print modifier called name.
+func formatModifier(name string) (byte, bool) {
+	switch name {
+	case "x", "o", "b", "c", "r":
+		return name[0], true
+	}
+	return 0, false
+}
+
+func isLoadOption(name string) bool {
+	switch name {
+	case "max-recurse", "max-array", "max-string", "follow-pointers", "hex-bytes":
+		return true
+	}
+	return false
+}
+
+// Sets the limit called name in cfg to value.
+func setLoadOption(cfg *proctl.LoadConfig, name, value string) error {
+	var flag *bool
+	switch name {
+	case "follow-pointers":
+		flag = &cfg.FollowPointers
+	case "hex-bytes":
+		flag = &cfg.HexDumpBytes
+	}
+	if flag != nil {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %s", name, value)
+		}
+		*flag = b
+		return nil
+	}
+
+	var limit *int
+	switch name {
+	case "max-recurse":
+		limit = &cfg.MaxVariableRecurse
+	case "max-array":
+		limit = &cfg.MaxArrayValues
+	case "max-string":
+		limit = &cfg.MaxStringLen
+	default:
+		return fmt.Errorf("unknown option %s", name)
+	}
+	// Strings can be requested whole, other values must be limited.
+	n, err := strconv.Atoi(value)
+	if err != nil || (n < 0 && limit != &cfg.MaxStringLen) {
+		return fmt.Errorf("invalid value for %s: %s", name, value)
+	}
+	if n < 0 {
+		n = -1
+	}
+	*limit = n
+	return nil
+}
+
 func filterVariables(vars []*proctl.Variable, filter *regexp.Regexp) []string {
 	data := make([]string, 0, len(vars))
 	for _, v := range vars {
@@ -284,13 +867,17 @@ func filterVariables(vars []*proctl.Variable, filter *regexp.Regexp) []string {
 			continue
 		}
 		if filter == nil || filter.Match([]byte(v.Name)) {
-			data = append(data, fmt.Sprintf("%s = %s", v.Name, v.Value))
+			if v.Shadowed {
+				data = append(data, fmt.Sprintf("%s = %s (shadowed)", v.Name, v.Value))
+			} else {
+				data = append(data, fmt.Sprintf("%s = %s", v.Name, v.Value))
+			}
 		}
 	}
 	return data
 }
 
-func info(p *proctl.DebuggedProcess, args ...string) error {
+func (c *Commands) info(p *proctl.DebuggedProcess, args ...string) error {
 	if len(args) == 0 {
 		return fmt.Errorf("not enough arguments. expected info type [regex].")
 	}
@@ -324,21 +911,21 @@ func info(p *proctl.DebuggedProcess, args ...string) error {
 		}
 
 	case "args":
-		vars, err := p.CurrentThread.FunctionArguments()
+		vars, err := p.CurrentThread.FunctionArguments(c.loadConfig)
 		if err != nil {
 			return nil
 		}
 		data = filterVariables(vars, filter)
 
 	case "locals":
-		vars, err := p.CurrentThread.LocalVariables()
+		vars, err := p.CurrentThread.LocalVariables(c.loadConfig)
 		if err != nil {
 			return nil
 		}
 		data = filterVariables(vars, filter)
 
 	case "vars":
-		vars, err := p.CurrentThread.PackageVariables()
+		vars, err := p.CurrentThread.PackageVariables(c.loadConfig)
 		if err != nil {
 			return nil
 		}
@@ -359,7 +946,12 @@ func info(p *proctl.DebuggedProcess, args ...string) error {
 }
 
 func printcontext(p *proctl.DebuggedProcess) error {
-	var context []string
+	if bp := p.CurrentBreakpoint; bp != nil && bp.WatchType != 0 {
+		fmt.Printf("%s hit\n\told value: %s\n\tnew value: %s\n", bp, bp.OldValue, bp.NewValue)
+		if bp.TriggerInst != "" {
+			fmt.Printf("\tby instruction: %#x %s\n", bp.TriggerPC, bp.TriggerInst)
+		}
+	}
 
 	regs, err := p.Registers()
 	if err != nil {
@@ -367,6 +959,15 @@ func printcontext(p *proctl.DebuggedProcess) error {
 	}
 
 	f, l, fn := p.PCToLine(regs.PC())
+	if fn == nil {
+		fmt.Printf("Stopped at: 0x%x\n", regs.PC())
+	}
+	return printfile(fn, f, l)
+}
+
+// Prints the source surrounding the given file and line.
+func printfile(fn *gosym.Func, f string, l int) error {
+	var context []string
 
 	if fn != nil {
 		fmt.Printf("current loc: %s %s:%d\n", fn.Name, f, l)
@@ -404,7 +1005,6 @@ func printcontext(p *proctl.DebuggedProcess) error {
 			context = append(context, fmt.Sprintf("\033[34m%s %d\033[0m: %s", arrow, i, line))
 		}
 	} else {
-		fmt.Printf("Stopped at: 0x%x\n", regs.PC())
 		context = append(context, "\033[34m=>\033[0m    no source available")
 	}
 
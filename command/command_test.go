@@ -2,6 +2,8 @@ package command
 
 import (
 	"fmt"
+	"go/parser"
+	"strings"
 	"testing"
 
 	"github.com/derekparker/delve/proctl"
@@ -52,9 +54,197 @@ func TestCommandReplayWithoutPreviousCommand(t *testing.T) {
 	}
 }
 
+func TestCommandFormatModifier(t *testing.T) {
+	cmds := DebugCommands()
+	for _, cmdstr := range []string{"p/z", "print/max-string", "p/"} {
+		err := cmds.Find(cmdstr)(nil, "a")
+		if err == nil || err.Error() != "unknown format modifier "+cmdstr[strings.IndexByte(cmdstr, '/')+1:] {
+			t.Fatalf("%s: expected unknown format modifier error, got %v", cmdstr, err)
+		}
+	}
+}
+
 func TestSwitchThread(t *testing.T) {
 	err := thread(nil, []string{}...)
 	if err == nil {
 		t.Fatal("expected error for empty arg slice")
 	}
 }
+
+func TestParseBreakpointArgs(t *testing.T) {
+	loc, gid, cond, err := parseBreakpointArgs([]string{"foo.go:13"}, parser.ParseExpr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc != "foo.go:13" || gid != 0 || cond != nil {
+		t.Fatalf("unexpected result %s %d %#v", loc, gid, cond)
+	}
+
+	loc, gid, cond, err = parseBreakpointArgs([]string{"foo.go:13", "if", "i", "==", "100", "&&", "name", "==", `"foo"`}, parser.ParseExpr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc != "foo.go:13" || gid != 0 || cond == nil {
+		t.Fatalf("unexpected result %s %d %#v", loc, gid, cond)
+	}
+
+	loc, gid, cond, err = parseBreakpointArgs([]string{"handler.go:88", "goroutine", "17"}, parser.ParseExpr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc != "handler.go:88" || gid != 17 || cond != nil {
+		t.Fatalf("unexpected result %s %d %#v", loc, gid, cond)
+	}
+
+	loc, gid, cond, err = parseBreakpointArgs([]string{"handler.go:88", "goroutine", "17", "if", "i", ">", "1"}, parser.ParseExpr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc != "handler.go:88" || gid != 17 || cond == nil {
+		t.Fatalf("unexpected result %s %d %#v", loc, gid, cond)
+	}
+
+	for _, args := range [][]string{
+		{}, {"foo.go:13", "if"}, {"foo.go:13", "when", "i"}, {"foo.go:13", "if", "i", "=="},
+		{"foo.go:13", "goroutine"}, {"foo.go:13", "goroutine", "x"}, {"foo.go:13", "goroutine", "0"},
+		{"foo.go:13", "if", "i", "goroutine", "1"},
+	} {
+		if _, _, _, err := parseBreakpointArgs(args, parser.ParseExpr); err == nil {
+			t.Fatalf("expected error for %v", args)
+		}
+	}
+}
+
+func TestParseAssignment(t *testing.T) {
+	for _, tc := range []struct {
+		in, name, value string
+	}{
+		{"x = 10", "x", "10"},
+		{"obj.count=1", "obj.count", "1"},
+		{"b = x == 1", "b", "x == 1"},
+		{"b = x != 1 && y <= 2", "b", "x != 1 && y <= 2"},
+		{`m["a=b"] = "c"`, `m["a=b"]`, `"c"`},
+		{`m["a\"=b"] = 1`, `m["a\"=b"]`, "1"},
+	} {
+		name, value, err := parseAssignment(tc.in)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.in, err)
+		}
+		if name != tc.name || value != tc.value {
+			t.Fatalf("%s: expected %q = %q, got %q = %q", tc.in, tc.name, tc.value, name, value)
+		}
+	}
+
+	for _, in := range []string{"", "x", "x == 1", "= 1", "x =", `m["a=b"]`} {
+		if _, _, err := parseAssignment(in); err == nil {
+			t.Fatalf("expected error for %q", in)
+		}
+	}
+}
+
+func TestParsePrintOptions(t *testing.T) {
+	cfg, args, err := parsePrintOptions(proctl.DefaultLoadConfig, []string{"-max-array", "100", "-follow-pointers", "false", "-hex-bytes", "true", "s[1000:1100]"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MaxArrayValues != 100 || cfg.FollowPointers || !cfg.HexDumpBytes || cfg.MaxStringLen != proctl.DefaultLoadConfig.MaxStringLen {
+		t.Fatalf("unexpected config %#v", cfg)
+	}
+	if len(args) != 1 || args[0] != "s[1000:1100]" {
+		t.Fatalf("unexpected arguments %v", args)
+	}
+
+	// Negated expressions are not options.
+	cfg, args, err = parsePrintOptions(proctl.DefaultLoadConfig, []string{"-a", "+", "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg != proctl.DefaultLoadConfig || len(args) != 3 {
+		t.Fatalf("unexpected result %#v %v", cfg, args)
+	}
+
+	// Format modifiers.
+	cfg, args, err = parsePrintOptions(proctl.DefaultLoadConfig, []string{"-x", "-max-array", "10", "flags"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Format != 'x' || cfg.MaxArrayValues != 10 || len(args) != 1 || args[0] != "flags" {
+		t.Fatalf("unexpected result %#v %v", cfg, args)
+	}
+	for _, args := range [][]string{{"-x"}, {"-x", "+", "1"}, {"-c", "==", "'a'"}} {
+		cfg, rest, err := parsePrintOptions(proctl.DefaultLoadConfig, args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Format != 0 || len(rest) != len(args) {
+			t.Fatalf("%v: unexpected result %#v %v", args, cfg, rest)
+		}
+	}
+
+	// Strings can be requested whole.
+	cfg, _, err = parsePrintOptions(proctl.LoadConfig{MaxStringLen: 64}, []string{"-max-string", "-5", "s"})
+	if err != nil || cfg.MaxStringLen != -1 {
+		t.Fatalf("unexpected result %#v %v", cfg, err)
+	}
+
+	for _, args := range [][]string{{"-max-array"}, {"-max-array", "x", "s"}, {"-max-array", "-1", "s"}, {"-follow-pointers", "maybe", "p"}} {
+		if _, _, err := parsePrintOptions(proctl.DefaultLoadConfig, args); err == nil {
+			t.Fatalf("expected error for %v", args)
+		}
+	}
+}
+
+func TestParseExamineArgs(t *testing.T) {
+	testcases := []struct {
+		args   []string
+		expr   string
+		count  int
+		format byte
+	}{
+		{[]string{"p"}, "p", 64, 'x'},
+		{[]string{"&buf[0]", "32"}, "&buf[0]", 32, 'x'},
+		{[]string{"&buf[0]", "4", "w"}, "&buf[0]", 4, 'w'},
+		{[]string{"main.main", "i"}, "main.main", 10, 'i'},
+		{[]string{"p", "+", "16"}, "p + 16", 64, 'x'},
+		{[]string{"p", "+", "16", "8", "a"}, "p + 16", 8, 'a'},
+		{[]string{"a"}, "a", 64, 'x'},
+		{[]string{"p", "100000000000"}, "p", maxExamineCount, 'x'},
+		{[]string{"p", "100000000000", "w"}, "p", maxExamineCount, 'w'},
+	}
+	for _, tc := range testcases {
+		expr, count, format, err := parseExamineArgs(tc.args)
+		if err != nil {
+			t.Fatalf("%v: %s", tc.args, err)
+		}
+		if expr != tc.expr || count != tc.count || format != tc.format {
+			t.Fatalf("%v: unexpected result %q %d %c", tc.args, expr, count, format)
+		}
+	}
+
+	for _, args := range [][]string{{}, {"p", "0"}} {
+		if _, _, _, err := parseExamineArgs(args); err == nil {
+			t.Fatalf("expected error for %v", args)
+		}
+	}
+}
+
+func TestFormatMemory(t *testing.T) {
+	data := []byte("hello, world\x00\x01\x02\x03\x04\x05\x06\x07")
+	testcases := []struct {
+		format   byte
+		expected string
+	}{
+		{'x', "0x1000: 68 65 6c 6c 6f 2c 20 77 6f 72 6c 64 00 01 02 03\n0x1010: 04 05 06 07\n"},
+		{'a', "0x1000: hello, world....\n0x1010: ....\n"},
+		{'w', "0x1000: 0x77202c6f6c6c6568 0x03020100646c726f\n"},
+	}
+	for _, tc := range testcases {
+		d := data
+		if tc.format == 'w' {
+			d = data[:16]
+		}
+		if s := formatMemory(0x1000, d, tc.format); s != tc.expected {
+			t.Fatalf("%c: expected %q got %q", tc.format, tc.expected, s)
+		}
+	}
+}
@@ -3,74 +3,710 @@ package op
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 
 	"github.com/derekparker/delve/dwarf/util"
 )
 
 const (
-	DW_OP_addr           = 0x3
-	DW_OP_call_frame_cfa = 0x9c
-	DW_OP_plus           = 0x22
-	DW_OP_consts         = 0x11
-	DW_OP_plus_uconsts   = 0x23
+	DW_OP_addr                = 0x03
+	DW_OP_deref               = 0x06
+	DW_OP_const1u             = 0x08
+	DW_OP_const1s             = 0x09
+	DW_OP_const2u             = 0x0a
+	DW_OP_const2s             = 0x0b
+	DW_OP_const4u             = 0x0c
+	DW_OP_const4s             = 0x0d
+	DW_OP_const8u             = 0x0e
+	DW_OP_const8s             = 0x0f
+	DW_OP_constu              = 0x10
+	DW_OP_consts              = 0x11
+	DW_OP_dup                 = 0x12
+	DW_OP_drop                = 0x13
+	DW_OP_over                = 0x14
+	DW_OP_pick                = 0x15
+	DW_OP_swap                = 0x16
+	DW_OP_rot                 = 0x17
+	DW_OP_xderef              = 0x18
+	DW_OP_abs                 = 0x19
+	DW_OP_and                 = 0x1a
+	DW_OP_div                 = 0x1b
+	DW_OP_minus               = 0x1c
+	DW_OP_mod                 = 0x1d
+	DW_OP_mul                 = 0x1e
+	DW_OP_neg                 = 0x1f
+	DW_OP_not                 = 0x20
+	DW_OP_or                  = 0x21
+	DW_OP_plus                = 0x22
+	DW_OP_plus_uconsts        = 0x23
+	DW_OP_shl                 = 0x24
+	DW_OP_shr                 = 0x25
+	DW_OP_shra                = 0x26
+	DW_OP_xor                 = 0x27
+	DW_OP_bra                 = 0x28
+	DW_OP_eq                  = 0x29
+	DW_OP_ge                  = 0x2a
+	DW_OP_gt                  = 0x2b
+	DW_OP_le                  = 0x2c
+	DW_OP_lt                  = 0x2d
+	DW_OP_ne                  = 0x2e
+	DW_OP_skip                = 0x2f
+	DW_OP_lit0                = 0x30
+	DW_OP_lit31               = 0x4f
+	DW_OP_reg0                = 0x50
+	DW_OP_reg31               = 0x6f
+	DW_OP_breg0               = 0x70
+	DW_OP_breg31              = 0x8f
+	DW_OP_regx                = 0x90
+	DW_OP_fbreg               = 0x91
+	DW_OP_bregx               = 0x92
+	DW_OP_piece               = 0x93
+	DW_OP_deref_size          = 0x94
+	DW_OP_xderef_size         = 0x95
+	DW_OP_nop                 = 0x96
+	DW_OP_push_object_address = 0x97
+	DW_OP_call2               = 0x98
+	DW_OP_call4               = 0x99
+	DW_OP_call_ref            = 0x9a
+	DW_OP_form_tls_address    = 0x9b
+	DW_OP_call_frame_cfa      = 0x9c
+	DW_OP_bit_piece           = 0x9d
+	DW_OP_implicit_value      = 0x9e
+	DW_OP_stack_value         = 0x9f
 )
 
-type stackfn func(*bytes.Buffer, []int64, int64) ([]int64, error)
+// Size of addresses and of the values read by DW_OP_deref.
+const ptrSize = 8
 
-var oplut = map[byte]stackfn{
-	DW_OP_call_frame_cfa: callframecfa,
-	DW_OP_plus:           plus,
-	DW_OP_consts:         consts,
-	DW_OP_addr:           addr,
-	DW_OP_plus_uconsts:   plusuconsts,
+// Maximum number of operations executed by a location expression.
+// Branches make it possible to write expressions that never end.
+const maxOperations = 10000
+
+var errTruncated = errors.New("truncated location expression")
+
+// Context is the frame a location expression is evaluated in, providing
+// its registers and the memory of the process.
+type Context interface {
+	// Canonical frame address of the frame.
+	CFA() int64
+	// Address DW_OP_fbreg offsets are relative to, the value of the
+	// DW_AT_frame_base of the frame's function.
+	FrameBase() int64
+	// Returns the value of the register with DWARF number n.
+	Register(n uint64) (uint64, error)
+	// Reads size bytes of memory at addr.
+	ReadMemory(addr uintptr, size int) ([]byte, error)
+}
+
+// PieceKind tells where a piece of a value is stored.
+type PieceKind uint8
+
+const (
+	// The piece is in memory at Addr.
+	AddrPiece PieceKind = iota
+	// The piece is in the register RegNum.
+	RegPiece
+	// The piece is not stored anywhere, its value is Value, or Bytes
+	// for DW_OP_implicit_value.
+	ImmPiece
+	// The piece was optimized away.
+	EmptyPiece
+)
+
+// Piece is a part of a value that is not stored contiguously in memory.
+// A Size of 0 means the whole value.
+type Piece struct {
+	Size   int
+	Kind   PieceKind
+	Addr   int64
+	RegNum uint64
+	Value  int64
+	Bytes  []byte
+}
+
+// The location described by the operations since the last piece.
+type location uint8
+
+const (
+	memoryLocation location = iota
+	registerLocation
+	stackValueLocation
+	implicitLocation
+)
+
+type machine struct {
+	ctx          Context
+	instructions []byte
+	buf          *bytes.Buffer
+	stack        []int64
+
+	loc      location
+	reg      uint64
+	implicit []byte
+	pieces   []Piece
+}
+
+type stackfn func(m *machine, opcode byte) error
+
+var oplut map[byte]stackfn
+
+func init() {
+	oplut = map[byte]stackfn{
+		DW_OP_addr:                addr,
+		DW_OP_deref:               deref,
+		DW_OP_const1u:             constant,
+		DW_OP_const1s:             constant,
+		DW_OP_const2u:             constant,
+		DW_OP_const2s:             constant,
+		DW_OP_const4u:             constant,
+		DW_OP_const4s:             constant,
+		DW_OP_const8u:             constant,
+		DW_OP_const8s:             constant,
+		DW_OP_constu:              constu,
+		DW_OP_consts:              consts,
+		DW_OP_dup:                 pick,
+		DW_OP_drop:                drop,
+		DW_OP_over:                pick,
+		DW_OP_pick:                pick,
+		DW_OP_swap:                swap,
+		DW_OP_rot:                 rot,
+		DW_OP_abs:                 unaryop,
+		DW_OP_neg:                 unaryop,
+		DW_OP_not:                 unaryop,
+		DW_OP_and:                 binaryop,
+		DW_OP_div:                 binaryop,
+		DW_OP_minus:               binaryop,
+		DW_OP_mod:                 binaryop,
+		DW_OP_mul:                 binaryop,
+		DW_OP_or:                  binaryop,
+		DW_OP_plus:                binaryop,
+		DW_OP_shl:                 binaryop,
+		DW_OP_shr:                 binaryop,
+		DW_OP_shra:                binaryop,
+		DW_OP_xor:                 binaryop,
+		DW_OP_eq:                  binaryop,
+		DW_OP_ge:                  binaryop,
+		DW_OP_gt:                  binaryop,
+		DW_OP_le:                  binaryop,
+		DW_OP_lt:                  binaryop,
+		DW_OP_ne:                  binaryop,
+		DW_OP_plus_uconsts:        plusuconsts,
+		DW_OP_bra:                 branch,
+		DW_OP_skip:                branch,
+		DW_OP_regx:                register,
+		DW_OP_fbreg:               fbreg,
+		DW_OP_bregx:               bregister,
+		DW_OP_piece:               piece,
+		DW_OP_deref_size:          deref,
+		DW_OP_nop:                 nop,
+		DW_OP_call_frame_cfa:      callframecfa,
+		DW_OP_implicit_value:      implicitvalue,
+		DW_OP_stack_value:         stackvalue,
+		DW_OP_xderef:              unsupported,
+		DW_OP_xderef_size:         unsupported,
+		DW_OP_push_object_address: unsupported,
+		DW_OP_call2:               unsupported,
+		DW_OP_call4:               unsupported,
+		DW_OP_call_ref:            unsupported,
+		DW_OP_form_tls_address:    unsupported,
+		DW_OP_bit_piece:           unsupported,
+	}
+	for opcode := DW_OP_lit0; opcode <= DW_OP_lit31; opcode++ {
+		oplut[byte(opcode)] = literal
+	}
+	for opcode := DW_OP_reg0; opcode <= DW_OP_reg31; opcode++ {
+		oplut[byte(opcode)] = register
+	}
+	for opcode := DW_OP_breg0; opcode <= DW_OP_breg31; opcode++ {
+		oplut[byte(opcode)] = bregister
+	}
 }
 
+// Executes the location expression instructions in the frame whose
+// canonical frame address is cfa, returning the address of the value.
+// Expressions using registers or memory can not be evaluated.
 func ExecuteStackProgram(cfa int64, instructions []byte) (int64, error) {
-	stack := make([]int64, 0, 3)
-	buf := bytes.NewBuffer(instructions)
+	addr, pieces, err := Execute(cfaContext(cfa), instructions)
+	if err != nil {
+		return 0, err
+	}
+	if pieces != nil {
+		return 0, errors.New("value is not in memory")
+	}
+	return addr, nil
+}
+
+// Executes the location expression instructions in ctx. Values stored
+// in memory are described by their address. The location of any other
+// value is described by the pieces it is made of, a single piece of
+// size 0 for values held whole by a register or computed by the
+// expression.
+func Execute(ctx Context, instructions []byte) (int64, []Piece, error) {
+	m := &machine{ctx: ctx, instructions: instructions, buf: bytes.NewBuffer(instructions), stack: make([]int64, 0, 3)}
 
-	for ocfaode, err := buf.ReadByte(); err == nil; ocfaode, err = buf.ReadByte() {
-		fn, ok := oplut[ocfaode]
+	for ops := 0; ; ops++ {
+		opcode, err := m.buf.ReadByte()
+		if err != nil {
+			break
+		}
+		if ops == maxOperations {
+			return 0, nil, fmt.Errorf("location expression executed more than %d operations", maxOperations)
+		}
+		fn, ok := oplut[opcode]
 		if !ok {
-			return 0, fmt.Errorf("invalid instruction %#v", ocfaode)
+			return 0, nil, fmt.Errorf("invalid instruction %#v", opcode)
+		}
+		if err := fn(m, opcode); err != nil {
+			return 0, nil, err
 		}
+	}
+
+	if m.pieces != nil {
+		return 0, m.pieces, nil
+	}
+	switch m.loc {
+	case registerLocation, stackValueLocation, implicitLocation:
+		p, err := m.piece(0)
+		if err != nil {
+			return 0, nil, err
+		}
+		return 0, []Piece{p}, nil
+	}
+	if len(m.stack) == 0 {
+		return 0, nil, errors.New("empty location expression")
+	}
+	return m.stack[len(m.stack)-1], nil, nil
+}
+
+func (m *machine) push(n int64) {
+	m.stack = append(m.stack, n)
+}
+
+func (m *machine) pop() (int64, error) {
+	if len(m.stack) == 0 {
+		return 0, errors.New("stack underflow")
+	}
+	n := m.stack[len(m.stack)-1]
+	m.stack = m.stack[:len(m.stack)-1]
+	return n, nil
+}
+
+// Reads a fixed size little endian operand.
+func (m *machine) operand(size int) (uint64, error) {
+	b := m.buf.Next(size)
+	if len(b) < size {
+		return 0, errTruncated
+	}
+	var n uint64
+	for i := size - 1; i >= 0; i-- {
+		n = n<<8 | uint64(b[i])
+	}
+	return n, nil
+}
+
+// Reads an unsigned LEB128 operand.
+func (m *machine) uleb128() (uint64, error) {
+	if !m.lebTerminated() {
+		return 0, errTruncated
+	}
+	n, _ := util.DecodeULEB128(m.buf)
+	return n, nil
+}
+
+// Reads a signed LEB128 operand.
+func (m *machine) sleb128() (int64, error) {
+	if !m.lebTerminated() {
+		return 0, errTruncated
+	}
+	n, _ := util.DecodeSLEB128(m.buf)
+	return n, nil
+}
+
+// Reports whether the remaining instructions hold a whole LEB128 number,
+// which ends with the first byte whose high bit is clear. The decoders
+// of package util panic on truncated numbers.
+func (m *machine) lebTerminated() bool {
+	for _, b := range m.buf.Bytes() {
+		if b&0x80 == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the piece of the given size described by the operations since
+// the last piece, and starts the next one.
+func (m *machine) piece(size int) (Piece, error) {
+	p := Piece{Size: size}
+	switch m.loc {
+	case registerLocation:
+		p.Kind, p.RegNum = RegPiece, m.reg
+	case stackValueLocation:
+		n, err := m.pop()
+		if err != nil {
+			return p, err
+		}
+		p.Kind, p.Value = ImmPiece, n
+	case implicitLocation:
+		p.Kind, p.Bytes = ImmPiece, m.implicit
+	default:
+		if len(m.stack) == 0 {
+			p.Kind = EmptyPiece
+			break
+		}
+		p.Kind, p.Addr = AddrPiece, m.stack[len(m.stack)-1]
+		m.stack = m.stack[:len(m.stack)-1]
+	}
+	m.loc = memoryLocation
+	return p, nil
+}
+
+func callframecfa(m *machine, opcode byte) error {
+	m.push(m.ctx.CFA())
+	return nil
+}
+
+func addr(m *machine, opcode byte) error {
+	n, err := m.operand(ptrSize)
+	m.push(int64(n))
+	return err
+}
+
+func deref(m *machine, opcode byte) error {
+	size := ptrSize
+	if opcode == DW_OP_deref_size {
+		b, err := m.buf.ReadByte()
+		if err != nil {
+			return errTruncated
+		}
+		size = int(b)
+	}
+	if size <= 0 || size > ptrSize {
+		return fmt.Errorf("invalid dereference size %d", size)
+	}
+	a, err := m.pop()
+	if err != nil {
+		return err
+	}
+	data, err := m.ctx.ReadMemory(uintptr(a), size)
+	if err != nil {
+		return err
+	}
+	val := make([]byte, ptrSize)
+	copy(val, data)
+	m.push(int64(binary.LittleEndian.Uint64(val)))
+	return nil
+}
+
+func literal(m *machine, opcode byte) error {
+	m.push(int64(opcode - DW_OP_lit0))
+	return nil
+}
+
+func constant(m *machine, opcode byte) error {
+	size := 1 << uint((opcode-DW_OP_const1u)/2)
+	n, err := m.operand(size)
+	if err != nil {
+		return err
+	}
+	// The s variants are sign extended.
+	if (opcode-DW_OP_const1u)%2 == 1 && size < 8 {
+		shift := uint(64 - 8*size)
+		m.push(int64(n<<shift) >> shift)
+		return nil
+	}
+	m.push(int64(n))
+	return nil
+}
+
+func constu(m *machine, opcode byte) error {
+	num, err := m.uleb128()
+	if err != nil {
+		return err
+	}
+	m.push(int64(num))
+	return nil
+}
 
-		stack, err = fn(buf, stack, cfa)
+func consts(m *machine, opcode byte) error {
+	num, err := m.sleb128()
+	if err != nil {
+		return err
+	}
+	m.push(num)
+	return nil
+}
+
+func pick(m *machine, opcode byte) error {
+	var idx int
+	switch opcode {
+	case DW_OP_over:
+		idx = 1
+	case DW_OP_pick:
+		b, err := m.buf.ReadByte()
 		if err != nil {
-			return 0, err
+			return errTruncated
+		}
+		idx = int(b)
+	}
+	if idx >= len(m.stack) {
+		return errors.New("stack underflow")
+	}
+	m.push(m.stack[len(m.stack)-1-idx])
+	return nil
+}
+
+func drop(m *machine, opcode byte) error {
+	_, err := m.pop()
+	return err
+}
+
+func swap(m *machine, opcode byte) error {
+	n := len(m.stack)
+	if n < 2 {
+		return errors.New("stack underflow")
+	}
+	m.stack[n-1], m.stack[n-2] = m.stack[n-2], m.stack[n-1]
+	return nil
+}
+
+// Moves the top entry of the stack to the third position, the second
+// and third entries moving up one position.
+func rot(m *machine, opcode byte) error {
+	n := len(m.stack)
+	if n < 3 {
+		return errors.New("stack underflow")
+	}
+	m.stack[n-1], m.stack[n-2], m.stack[n-3] = m.stack[n-2], m.stack[n-3], m.stack[n-1]
+	return nil
+}
+
+func unaryop(m *machine, opcode byte) error {
+	n, err := m.pop()
+	if err != nil {
+		return err
+	}
+	switch opcode {
+	case DW_OP_abs:
+		if n < 0 {
+			n = -n
 		}
+	case DW_OP_neg:
+		n = -n
+	case DW_OP_not:
+		n = ^n
 	}
+	m.push(n)
+	return nil
+}
 
-	return stack[len(stack)-1], nil
+// Pops the two top entries of the stack and pushes the result of the
+// operation, the top entry being its second operand.
+func binaryop(m *machine, opcode byte) error {
+	b, err := m.pop()
+	if err != nil {
+		return err
+	}
+	a, err := m.pop()
+	if err != nil {
+		return err
+	}
+
+	var r int64
+	switch opcode {
+	case DW_OP_and:
+		r = a & b
+	case DW_OP_or:
+		r = a | b
+	case DW_OP_xor:
+		r = a ^ b
+	case DW_OP_plus:
+		r = a + b
+	case DW_OP_minus:
+		r = a - b
+	case DW_OP_mul:
+		r = a * b
+	case DW_OP_div:
+		if b == 0 {
+			return errors.New("division by zero")
+		}
+		r = a / b
+	case DW_OP_mod:
+		if b == 0 {
+			return errors.New("division by zero")
+		}
+		r = int64(uint64(a) % uint64(b))
+	case DW_OP_shl:
+		r = a << uint64(b)
+	case DW_OP_shr:
+		r = int64(uint64(a) >> uint64(b))
+	case DW_OP_shra:
+		r = a >> uint64(b)
+	case DW_OP_eq:
+		r = boolValue(a == b)
+	case DW_OP_ne:
+		r = boolValue(a != b)
+	case DW_OP_ge:
+		r = boolValue(a >= b)
+	case DW_OP_gt:
+		r = boolValue(a > b)
+	case DW_OP_le:
+		r = boolValue(a <= b)
+	case DW_OP_lt:
+		r = boolValue(a < b)
+	}
+	m.push(r)
+	return nil
 }
 
-func callframecfa(buf *bytes.Buffer, stack []int64, cfa int64) ([]int64, error) {
-	return append(stack, int64(cfa)), nil
+func boolValue(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
 }
 
-func addr(buf *bytes.Buffer, stack []int64, cfa int64) ([]int64, error) {
-	return append(stack, int64(binary.LittleEndian.Uint64(buf.Next(8)))), nil
+func plusuconsts(m *machine, opcode byte) error {
+	n, err := m.pop()
+	if err != nil {
+		return err
+	}
+	num, err := m.uleb128()
+	if err != nil {
+		return err
+	}
+	m.push(n + int64(num))
+	return nil
 }
 
-func plus(buf *bytes.Buffer, stack []int64, cfa int64) ([]int64, error) {
+// DW_OP_skip always, and DW_OP_bra if the popped entry is not zero,
+// continue at the given offset from the following operation.
+func branch(m *machine, opcode byte) error {
+	off, err := m.operand(2)
+	if err != nil {
+		return err
+	}
+	if opcode == DW_OP_bra {
+		n, err := m.pop()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+	}
+	pos := len(m.instructions) - m.buf.Len() + int(int16(off))
+	if pos < 0 || pos > len(m.instructions) {
+		return fmt.Errorf("branch out of the location expression")
+	}
+	m.buf = bytes.NewBuffer(m.instructions[pos:])
+	return nil
+}
+
+func register(m *machine, opcode byte) error {
+	if opcode == DW_OP_regx {
+		reg, err := m.uleb128()
+		if err != nil {
+			return err
+		}
+		m.reg = reg
+	} else {
+		m.reg = uint64(opcode - DW_OP_reg0)
+	}
+	m.loc = registerLocation
+	return nil
+}
+
+func bregister(m *machine, opcode byte) error {
 	var (
-		slen   = len(stack)
-		digits = stack[slen-2 : slen]
-		st     = stack[:slen-2]
+		reg uint64
+		err error
 	)
+	if opcode == DW_OP_bregx {
+		if reg, err = m.uleb128(); err != nil {
+			return err
+		}
+	} else {
+		reg = uint64(opcode - DW_OP_breg0)
+	}
+	off, err := m.sleb128()
+	if err != nil {
+		return err
+	}
+	val, err := m.ctx.Register(reg)
+	if err != nil {
+		return err
+	}
+	m.push(int64(val) + off)
+	return nil
+}
+
+func fbreg(m *machine, opcode byte) error {
+	off, err := m.sleb128()
+	if err != nil {
+		return err
+	}
+	m.push(m.ctx.FrameBase() + off)
+	return nil
+}
+
+func piece(m *machine, opcode byte) error {
+	size, err := m.uleb128()
+	if err != nil {
+		return err
+	}
+	p, err := m.piece(int(size))
+	if err != nil {
+		return err
+	}
+	m.pieces = append(m.pieces, p)
+	return nil
+}
+
+func nop(m *machine, opcode byte) error {
+	return nil
+}
+
+func implicitvalue(m *machine, opcode byte) error {
+	size, err := m.uleb128()
+	if err != nil {
+		return err
+	}
+	if size > uint64(m.buf.Len()) {
+		return errTruncated
+	}
+	b := m.buf.Next(int(size))
+	m.implicit = append([]byte(nil), b...)
+	m.loc = implicitLocation
+	return nil
+}
+
+func stackvalue(m *machine, opcode byte) error {
+	m.loc = stackValueLocation
+	return nil
+}
+
+func unsupported(m *machine, opcode byte) error {
+	return fmt.Errorf("unsupported instruction %#v", opcode)
+}
+
+// Context of a frame of which only the CFA is known.
+type cfaContext int64
+
+func (cfa cfaContext) CFA() int64 {
+	return int64(cfa)
+}
 
-	return append(st, digits[0]+digits[1]), nil
+// Go functions use the CFA as their frame base.
+func (cfa cfaContext) FrameBase() int64 {
+	return int64(cfa)
 }
 
-func plusuconsts(buf *bytes.Buffer, stack []int64, cfa int64) ([]int64, error) {
-	slen := len(stack)
-	num, _ := util.DecodeULEB128(buf)
-	stack[slen-1] = stack[slen-1] + int64(num)
-	return stack, nil
+func (cfa cfaContext) Register(n uint64) (uint64, error) {
+	return 0, fmt.Errorf("register %d not available", n)
 }
 
-func consts(buf *bytes.Buffer, stack []int64, cfa int64) ([]int64, error) {
-	num, _ := util.DecodeSLEB128(buf)
-	return append(stack, num), nil
+func (cfa cfaContext) ReadMemory(addr uintptr, size int) ([]byte, error) {
+	return nil, errors.New("memory not available")
 }
@@ -1,6 +1,10 @@
 package op
 
-import "testing"
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
 
 func TestExecuteStackProgram(t *testing.T) {
 	var (
@@ -16,3 +20,157 @@ func TestExecuteStackProgram(t *testing.T) {
 		t.Fatalf("actual %d != expected %d", actual, expected)
 	}
 }
+
+type testContext struct {
+	cfa  int64
+	regs map[uint64]uint64
+	mem  map[uintptr][]byte
+}
+
+func (ctx *testContext) CFA() int64 {
+	return ctx.cfa
+}
+
+func (ctx *testContext) FrameBase() int64 {
+	return ctx.cfa
+}
+
+func (ctx *testContext) Register(n uint64) (uint64, error) {
+	val, ok := ctx.regs[n]
+	if !ok {
+		return 0, fmt.Errorf("no register %d", n)
+	}
+	return val, nil
+}
+
+func (ctx *testContext) ReadMemory(addr uintptr, size int) ([]byte, error) {
+	data, ok := ctx.mem[addr]
+	if !ok || len(data) < size {
+		return nil, fmt.Errorf("no memory at %#x", addr)
+	}
+	return data[:size], nil
+}
+
+func TestExecute(t *testing.T) {
+	ctx := &testContext{
+		cfa:  0x1000,
+		regs: map[uint64]uint64{0: 7, 7: 0x2000, 17: 0x3000},
+		mem: map[uintptr][]byte{
+			0x2010: {0x00, 0x40, 0, 0, 0, 0, 0, 0},
+			0x4000: {0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		},
+	}
+
+	testcases := []struct {
+		name         string
+		instructions []byte
+		addr         int64
+	}{
+		{"addr", []byte{DW_OP_addr, 0x10, 0x20, 0, 0, 0, 0, 0, 0}, 0x2010},
+		{"fbreg", []byte{DW_OP_fbreg, 0x78}, 0x1000 - 8},
+		{"call_frame_cfa", []byte{DW_OP_call_frame_cfa, DW_OP_consts, 0x10, DW_OP_plus}, 0x1010},
+		{"breg", []byte{DW_OP_breg0 + 7, 0x10}, 0x2010},
+		{"bregx", []byte{DW_OP_bregx, 17, 0x08}, 0x3008},
+		{"deref", []byte{DW_OP_breg0 + 7, 0x10, DW_OP_deref}, 0x4000},
+		{"deref_size", []byte{DW_OP_lit0 + 1, DW_OP_const2u, 0x00, 0x40, DW_OP_deref_size, 1, DW_OP_plus}, 0x100},
+		{"const1s", []byte{DW_OP_const1s, 0xfe}, -2},
+		{"const4s", []byte{DW_OP_const4s, 0xfe, 0xff, 0xff, 0xff}, -2},
+		{"const8u", []byte{DW_OP_const8u, 1, 0, 0, 0, 0, 0, 0, 0x80}, -0x7fffffffffffffff},
+		{"constu", []byte{DW_OP_constu, 0x80, 0x01}, 128},
+		{"plus_uconst", []byte{DW_OP_lit0 + 2, DW_OP_plus_uconsts, 0x80, 0x01}, 130},
+		{"arithmetic", []byte{DW_OP_lit0 + 9, DW_OP_lit0 + 4, DW_OP_minus, DW_OP_lit0 + 3, DW_OP_mul, DW_OP_lit0 + 4, DW_OP_mod}, 3},
+		{"div", []byte{DW_OP_lit0 + 7, DW_OP_neg, DW_OP_lit0 + 2, DW_OP_div}, -3},
+		{"abs", []byte{DW_OP_const1s, 0xf0, DW_OP_abs}, 16},
+		{"logic", []byte{DW_OP_lit0 + 12, DW_OP_lit0 + 10, DW_OP_and, DW_OP_lit0 + 1, DW_OP_or, DW_OP_lit0 + 3, DW_OP_xor}, 10},
+		{"not", []byte{DW_OP_lit0, DW_OP_not}, -1},
+		{"shifts", []byte{DW_OP_lit0 + 1, DW_OP_lit0 + 4, DW_OP_shl, DW_OP_lit0 + 2, DW_OP_shr}, 4},
+		{"shra", []byte{DW_OP_const1s, 0xf0, DW_OP_lit0 + 2, DW_OP_shra}, -4},
+		{"shr", []byte{DW_OP_const1s, 0xff, DW_OP_const1u, 60, DW_OP_shr}, 15},
+		{"dup", []byte{DW_OP_lit0 + 3, DW_OP_dup, DW_OP_mul}, 9},
+		{"over", []byte{DW_OP_lit0 + 3, DW_OP_lit0 + 4, DW_OP_over}, 3},
+		{"pick", []byte{DW_OP_lit0 + 5, DW_OP_lit0 + 6, DW_OP_lit0 + 7, DW_OP_pick, 2}, 5},
+		{"swap", []byte{DW_OP_lit0 + 8, DW_OP_lit0 + 2, DW_OP_swap, DW_OP_minus}, -6},
+		{"drop", []byte{DW_OP_lit0 + 8, DW_OP_lit0 + 2, DW_OP_drop}, 8},
+		{"rot", []byte{DW_OP_lit0 + 1, DW_OP_lit0 + 2, DW_OP_lit0 + 3, DW_OP_rot, DW_OP_drop}, 1},
+		{"comparisons", []byte{DW_OP_lit0 + 1, DW_OP_lit0 + 2, DW_OP_lt, DW_OP_lit0 + 2, DW_OP_lit0 + 2, DW_OP_ge, DW_OP_plus, DW_OP_lit0 + 2, DW_OP_lit0 + 3, DW_OP_eq, DW_OP_plus}, 2},
+		{"skip", []byte{DW_OP_lit0 + 1, DW_OP_skip, 0x01, 0x00, DW_OP_drop, DW_OP_nop}, 1},
+		{"bra taken", []byte{DW_OP_lit0 + 1, DW_OP_lit0 + 1, DW_OP_bra, 0x01, 0x00, DW_OP_drop}, 1},
+		{"bra not taken", []byte{DW_OP_lit0 + 1, DW_OP_lit0 + 2, DW_OP_lit0, DW_OP_bra, 0x01, 0x00, DW_OP_drop}, 1},
+		{"loop", []byte{DW_OP_lit0, DW_OP_plus_uconsts, 1, DW_OP_dup, DW_OP_lit0 + 5, DW_OP_lt, DW_OP_bra, 0xf8, 0xff}, 5},
+	}
+
+	for _, tc := range testcases {
+		addr, pieces, err := Execute(ctx, tc.instructions)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if pieces != nil {
+			t.Fatalf("%s: unexpected pieces %v", tc.name, pieces)
+		}
+		if addr != tc.addr {
+			t.Fatalf("%s: expected %#x got %#x", tc.name, tc.addr, addr)
+		}
+	}
+}
+
+func TestExecutePieces(t *testing.T) {
+	ctx := &testContext{cfa: 0x1000}
+
+	testcases := []struct {
+		name         string
+		instructions []byte
+		pieces       []Piece
+	}{
+		{"reg", []byte{DW_OP_reg0 + 3}, []Piece{{Kind: RegPiece, RegNum: 3}}},
+		{"regx", []byte{DW_OP_regx, 17}, []Piece{{Kind: RegPiece, RegNum: 17}}},
+		{"stack_value", []byte{DW_OP_lit0 + 4, DW_OP_stack_value}, []Piece{{Kind: ImmPiece, Value: 4}}},
+		{"implicit_value", []byte{DW_OP_implicit_value, 2, 0xab, 0xcd}, []Piece{{Kind: ImmPiece, Bytes: []byte{0xab, 0xcd}}}},
+		{"split", []byte{DW_OP_reg0, DW_OP_piece, 8, DW_OP_fbreg, 0x70, DW_OP_piece, 8, DW_OP_piece, 4, DW_OP_lit0 + 1, DW_OP_stack_value, DW_OP_piece, 1},
+			[]Piece{{Size: 8, Kind: RegPiece}, {Size: 8, Kind: AddrPiece, Addr: 0x1000 - 16}, {Size: 4, Kind: EmptyPiece}, {Size: 1, Kind: ImmPiece, Value: 1}}},
+	}
+
+	for _, tc := range testcases {
+		_, pieces, err := Execute(ctx, tc.instructions)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if !reflect.DeepEqual(pieces, tc.pieces) {
+			t.Fatalf("%s: expected %v got %v", tc.name, tc.pieces, pieces)
+		}
+	}
+}
+
+func TestExecuteErrors(t *testing.T) {
+	ctx := &testContext{cfa: 0x1000}
+
+	for _, instructions := range [][]byte{
+		{DW_OP_plus},
+		{DW_OP_lit0 + 1, DW_OP_lit0, DW_OP_div},
+		{DW_OP_breg0 + 3, 0},
+		{DW_OP_lit0 + 1, DW_OP_deref},
+		{DW_OP_addr, 1, 2},
+		{DW_OP_skip, 0x10, 0x00},
+		{DW_OP_skip, 0xfd, 0xff},
+		{DW_OP_call2, 0, 0},
+		{DW_OP_constu},
+		{DW_OP_consts, 0x80},
+		{DW_OP_lit0, DW_OP_plus_uconsts, 0xff},
+		{DW_OP_regx},
+		{DW_OP_bregx, 0x80},
+		{DW_OP_bregx, 0x00},
+		{DW_OP_fbreg, 0x81, 0x82},
+		{DW_OP_reg0, DW_OP_piece},
+		{DW_OP_implicit_value},
+		{DW_OP_implicit_value, 0x02, 0x01},
+		{0xff},
+		{},
+	} {
+		if _, _, err := Execute(ctx, instructions); err == nil {
+			t.Fatalf("expected error executing %v", instructions)
+		}
+	}
+
+	if _, err := ExecuteStackProgram(0, []byte{DW_OP_reg0}); err == nil {
+		t.Fatal("expected error for a value held in a register")
+	}
+}
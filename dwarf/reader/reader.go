@@ -8,11 +8,20 @@ import (
 type Reader struct {
 	*dwarf.Reader
 	depth int
+	data  *dwarf.Data
+}
+
+// Variable is a variable or formal parameter in scope at some PC, along
+// with the depth of the lexical block declaring it, 0 being the function
+// itself.
+type Variable struct {
+	*dwarf.Entry
+	Depth int
 }
 
 // New returns a reader for the specified dwarf data.
 func New(data *dwarf.Data) *Reader {
-	return &Reader{data.Reader(), 0}
+	return &Reader{data.Reader(), 0, data}
 }
 
 // Seek moves the reader to an arbitrary offset.
@@ -47,8 +56,15 @@ func (reader *Reader) SeekToFunction(pc uint64) (*dwarf.Entry, error) {
 			continue
 		}
 
-		highpc, ok := entry.Val(dwarf.AttrHighpc).(uint64)
-		if !ok {
+		// DWARF 4 allows the high pc to be encoded as an offset from
+		// the low pc.
+		var highpc uint64
+		switch v := entry.Val(dwarf.AttrHighpc).(type) {
+		case uint64:
+			highpc = v
+		case int64:
+			highpc = lowpc + uint64(v)
+		default:
 			continue
 		}
 
@@ -121,6 +137,62 @@ func (reader *Reader) NextScopeVariable() (*dwarf.Entry, error) {
 	return nil, nil
 }
 
+// ScopeVariables returns the variables and formal parameters in scope at
+// pc of the function the reader was moved to by SeekToFunction. Besides
+// those of the function, these are the variables of the lexical blocks
+// containing pc, which are returned after the variables of the blocks
+// enclosing them.
+func (reader *Reader) ScopeVariables(pc uint64) ([]Variable, error) {
+	var vars []Variable
+	depth := 0
+	for entry, err := reader.Next(); entry != nil; entry, err = reader.Next() {
+		if err != nil {
+			return nil, err
+		}
+
+		switch entry.Tag {
+		case 0:
+			// End of the current block, or of the function.
+			if depth == 0 {
+				return vars, nil
+			}
+			depth--
+			continue
+		case dwarf.TagVariable, dwarf.TagFormalParameter:
+			vars = append(vars, Variable{entry, depth})
+		case dwarf.TagLexDwarfBlock:
+			if !entry.Children {
+				continue
+			}
+			contains, err := reader.containsPC(entry, pc)
+			if err != nil {
+				return nil, err
+			}
+			if contains {
+				depth++
+				continue
+			}
+		}
+		reader.SkipChildren()
+	}
+
+	return vars, nil
+}
+
+// Returns whether one of the PC ranges of entry contains pc.
+func (reader *Reader) containsPC(entry *dwarf.Entry, pc uint64) (bool, error) {
+	ranges, err := reader.data.Ranges(entry)
+	if err != nil {
+		return false, err
+	}
+	for _, r := range ranges {
+		if r[0] <= pc && pc < r[1] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 // NextMememberVariable moves the reader to the next debug entry that describes a member variable and returns the entry.
 func (reader *Reader) NextMemberVariable() (*dwarf.Entry, error) {
 	for entry, err := reader.Next(); entry != nil; entry, err = reader.Next() {
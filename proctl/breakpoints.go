@@ -1,8 +1,12 @@
 package proctl
 
 import (
+	"debug/dwarf"
 	"fmt"
+	"go/ast"
+	"go/token"
 	"runtime"
+	"strconv"
 )
 
 // Represents a single breakpoint. Stores information on the break
@@ -16,10 +20,121 @@ type BreakPoint struct {
 	OriginalData []byte
 	ID           int
 	Temp         bool
+
+	// Tracepoint breakpoints never stop execution, instead they log
+	// the location and the value of the expressions in Variables, or
+	// of the function arguments if it is empty, every time they are hit.
+	Tracepoint bool
+	Variables  []string
+
+	// WatchType is set for data watchpoints, which stop when the
+	// memory at Addr, holding the value of WatchExpr, is accessed.
+	WatchType WatchType
+	WatchExpr string
+	// Value of the watched memory before and after the access that
+	// last triggered the watchpoint, and the address and assembly of
+	// the instruction that did it.
+	OldValue    string
+	NewValue    string
+	TriggerPC   uint64
+	TriggerInst string
+
+	watchDwarfType dwarf.Type
+	// Whether the access that last triggered the watchpoint read the
+	// watched memory.
+	triggerRead bool
+
+	// GoroutineID, when not 0, restricts the breakpoint to the
+	// goroutine with that ID. Other goroutines never stop at it.
+	GoroutineID int
+	// Cond, when set, is a boolean expression evaluated every time
+	// the breakpoint is hit. Execution only stops if it is true.
+	Cond ast.Expr
+	// HitCond, when set, decides whether to stop based on the
+	// number of times the breakpoint has been hit.
+	HitCond *HitCondition
+
+	// Number of times the breakpoint has been hit, in total and
+	// by goroutine ID. Hits where Cond was false are not counted.
+	TotalHitCount uint64
+	HitCount      map[int]uint64
+}
+
+// HitCondition compares the hit count of a breakpoint against Val
+// using Op, which is one of ==, !=, <, <=, >, >= or %. The % operator
+// is true every Val-th hit.
+type HitCondition struct {
+	Op  token.Token
+	Val uint64
+}
+
+func (hc *HitCondition) String() string {
+	return fmt.Sprintf("hitcount %s %d", hc.Op, hc.Val)
+}
+
+// Returns whether the hit count n satisfies the condition.
+func (hc *HitCondition) check(n uint64) bool {
+	switch hc.Op {
+	case token.EQL:
+		return n == hc.Val
+	case token.NEQ:
+		return n != hc.Val
+	case token.LSS:
+		return n < hc.Val
+	case token.LEQ:
+		return n <= hc.Val
+	case token.GTR:
+		return n > hc.Val
+	case token.GEQ:
+		return n >= hc.Val
+	case token.REM:
+		return hc.Val != 0 && n%hc.Val == 0
+	}
+	return false
+}
+
+// Returns a hit condition from the string representation of its
+// operator and operand, as in `>= 50` or `% 10`.
+func ParseHitCondition(op string, val string) (*HitCondition, error) {
+	ops := map[string]token.Token{
+		"==": token.EQL, "!=": token.NEQ,
+		"<": token.LSS, "<=": token.LEQ,
+		">": token.GTR, ">=": token.GEQ,
+		"%": token.REM,
+	}
+	tok, ok := ops[op]
+	if !ok {
+		return nil, fmt.Errorf("invalid hit condition operator %s", op)
+	}
+	n, err := strconv.ParseUint(val, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hit count %s", val)
+	}
+	if tok == token.REM && n == 0 {
+		return nil, fmt.Errorf("hit condition %% 0 would never stop")
+	}
+	return &HitCondition{Op: tok, Val: n}, nil
 }
 
 func (bp *BreakPoint) String() string {
-	return fmt.Sprintf("Breakpoint %d at %#v %s:%d", bp.ID, bp.Addr, bp.File, bp.Line)
+	kind := "Breakpoint"
+	if bp.Tracepoint {
+		kind = "Tracepoint"
+	}
+	s := fmt.Sprintf("%s %d at %#v %s:%d", kind, bp.ID, bp.Addr, bp.File, bp.Line)
+	if bp.WatchType != 0 {
+		s = fmt.Sprintf("Watchpoint %d (%s %s) at %#v", bp.ID, bp.WatchType, bp.WatchExpr, bp.Addr)
+	}
+	if bp.GoroutineID != 0 {
+		s += fmt.Sprintf(" goroutine %d", bp.GoroutineID)
+	}
+	if bp.Cond != nil {
+		s += " if " + exprToString(bp.Cond)
+	}
+	if bp.HitCond != nil {
+		s += fmt.Sprintf(" (%s)", bp.HitCond)
+	}
+	return s
 }
 
 // Returned when trying to set a breakpoint at
@@ -68,6 +183,7 @@ func (dbp *DebuggedProcess) newBreakpoint(fn, f string, l int, addr uint64, data
 		Addr:         addr,
 		OriginalData: data,
 		ID:           dbp.breakpointIDCounter,
+		HitCount:     make(map[int]uint64),
 	}
 }
 
@@ -86,7 +202,7 @@ func (dbp *DebuggedProcess) setBreakpoint(tid int, addr uint64) (*BreakPoint, er
 			break
 		}
 		if v == nil {
-			if err := setHardwareBreakpoint(i, tid, addr); err != nil {
+			if err := dbp.setHardwareBreakpointAllThreads(i, addr, 0, 1); err != nil {
 				return nil, fmt.Errorf("could not set hardware breakpoint: %v", err)
 			}
 			dbp.HWBreakPoints[i] = dbp.newBreakpoint(fn.Name, f, l, addr, nil)
@@ -106,3 +222,134 @@ func (dbp *DebuggedProcess) setBreakpoint(tid int, addr uint64) (*BreakPoint, er
 	dbp.BreakPoints[addr] = dbp.newBreakpoint(fn.Name, f, l, addr, originalData)
 	return dbp.BreakPoints[addr], nil
 }
+
+// Programs hardware breakpoint `reg` into the debug registers of every
+// traced thread, so that it triggers no matter which thread runs into it.
+// A zero wtype sets an execution breakpoint, anything else a watchpoint
+// of `size` bytes. On failure the register is cleared on all threads.
+// Threads that are running can not be programmed, they are updated
+// before they are resumed next.
+func (dbp *DebuggedProcess) setHardwareBreakpointAllThreads(reg int, addr uint64, wtype WatchType, size int) error {
+	for tid, thread := range dbp.Threads {
+		if !threadStopped(tid) {
+			thread.hwBreakPointsStale = true
+			continue
+		}
+		if err := setHardwareBreakpointOfType(reg, tid, addr, wtype, size); err != nil {
+			dbp.clearHardwareBreakpointAllThreads(reg)
+			return err
+		}
+	}
+	return nil
+}
+
+// Clears hardware breakpoint `reg` on every traced thread. All threads
+// are cleared even if some of them fail, the first error is returned.
+func (dbp *DebuggedProcess) clearHardwareBreakpointAllThreads(reg int) error {
+	var firstErr error
+	for tid, thread := range dbp.Threads {
+		if !threadStopped(tid) {
+			thread.hwBreakPointsStale = true
+			continue
+		}
+		if err := clearHardwareBreakpoint(reg, tid); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Programs every hardware breakpoint into the debug registers of thread
+// tid, and clears the registers that are not in use. New threads start
+// with a copy of the debug registers of the thread that created them,
+// which may be out of date, so this must be done as soon as they are
+// traced.
+func (dbp *DebuggedProcess) setHardwareBreakpointsOnThread(tid int) error {
+	for i, bp := range dbp.HWBreakPoints {
+		if bp == nil {
+			if err := clearHardwareBreakpoint(i, tid); err != nil {
+				return err
+			}
+			continue
+		}
+		size := 1
+		if bp.WatchType != 0 {
+			size = int(bp.watchDwarfType.Size())
+		}
+		if err := setHardwareBreakpointOfType(i, tid, bp.Addr, bp.WatchType, size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setHardwareBreakpointOfType(reg, tid int, addr uint64, wtype WatchType, size int) error {
+	if wtype == 0 {
+		return setHardwareBreakpoint(reg, tid, addr)
+	}
+	return setWatchpoint(reg, tid, addr, wtype, size)
+}
+
+// Returns whether a thread that hit this breakpoint should stop, updating
+// the hit counts of the breakpoint along the way. g is the goroutine
+// running on the thread, nil if there is none. An error is returned if
+// the breakpoint condition could not be evaluated.
+func (bp *BreakPoint) checkCondition(thread *ThreadContext, g *G) (bool, error) {
+	// Read watchpoints also trap on writes, tell them apart
+	// by the instruction that accessed the memory.
+	if bp.WatchType == WatchRead && !bp.triggerRead {
+		return false, nil
+	}
+	// A thread that is not running a goroutine can not be
+	// running the one we want either.
+	if bp.GoroutineID != 0 && (g == nil || g.Id != bp.GoroutineID) {
+		return false, nil
+	}
+	if bp.Cond != nil {
+		stop, err := thread.evalBool(bp.Cond)
+		if err != nil || !stop {
+			return stop, err
+		}
+	}
+
+	bp.TotalHitCount++
+	if g != nil {
+		bp.HitCount[g.Id]++
+	}
+
+	if bp.HitCond != nil {
+		return bp.HitCond.check(bp.TotalHitCount), nil
+	}
+	return true, nil
+}
+
+// Returns the message logged when thread, running goroutine g, hits
+// this tracepoint.
+func (bp *BreakPoint) traceMessage(thread *ThreadContext, g *G) string {
+	gid := "?"
+	if g != nil {
+		gid = strconv.Itoa(g.Id)
+	}
+	msg := fmt.Sprintf("> goroutine(%s): %s %s:%d", gid, bp.FunctionName, bp.File, bp.Line)
+
+	var vars []*Variable
+	if len(bp.Variables) == 0 {
+		args, err := thread.FunctionArguments(DefaultLoadConfig)
+		if err != nil {
+			return fmt.Sprintf("%s (could not read arguments: %s)", msg, err)
+		}
+		vars = args
+	}
+	for _, name := range bp.Variables {
+		v, err := thread.EvalExpression(name, DefaultLoadConfig)
+		if err != nil {
+			msg += fmt.Sprintf("\n\t%s = <%s>", name, err)
+			continue
+		}
+		vars = append(vars, v)
+	}
+	for _, v := range vars {
+		msg += fmt.Sprintf("\n\t%s = %s", v.Name, v.Value)
+	}
+	return msg
+}
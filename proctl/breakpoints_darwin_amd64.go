@@ -11,3 +11,18 @@ func setHardwareBreakpoint(reg, tid int, addr uint64) error {
 func clearHardwareBreakpoint(reg, tid int) error {
 	return fmt.Errorf("not implemented on darwin")
 }
+
+// TODO(darwin)
+func setWatchpoint(reg, tid int, addr uint64, wtype WatchType, size int) error {
+	return fmt.Errorf("not implemented on darwin")
+}
+
+// TODO(darwin)
+func hardwareBreakpointHit(tid int) (int, error) {
+	return -1, nil
+}
+
+// TODO(darwin)
+func threadStopped(tid int) bool {
+	return true
+}
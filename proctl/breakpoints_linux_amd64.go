@@ -22,6 +22,40 @@ import "fmt"
 // that we want to break at. There are only 4 debug registers
 // DR0-DR3. Debug register 7 is the control register.
 func setHardwareBreakpoint(reg, tid int, addr uint64) error {
+	return setDebugRegister(reg, tid, addr, C.DR_RW_EXECUTE|C.DR_LEN_1)
+}
+
+// Sets a watchpoint in debug register `reg`, raising a debug exception
+// once an instruction accesses the `size` bytes stored at addr. The
+// hardware can not trap on reads alone, so read watchpoints trap on
+// writes as well.
+func setWatchpoint(reg, tid int, addr uint64, wtype WatchType, size int) error {
+	rw := uintptr(C.DR_RW_WRITE)
+	if wtype&WatchRead != 0 {
+		rw = C.DR_RW_READ
+	}
+	var length uintptr
+	switch size {
+	case 1:
+		length = C.DR_LEN_1
+	case 2:
+		length = C.DR_LEN_2
+	case 4:
+		length = C.DR_LEN_4
+	case 8:
+		length = C.DR_LEN_8
+	default:
+		return fmt.Errorf("invalid watchpoint size %d", size)
+	}
+	if addr%uint64(size) != 0 {
+		return fmt.Errorf("watchpoint address %#v is not aligned to %d bytes", addr, size)
+	}
+	return setDebugRegister(reg, tid, addr, rw|length)
+}
+
+// Programs debug register `reg` with addr, and sets the RW and LEN
+// bits `ctl` for it in the control register.
+func setDebugRegister(reg, tid int, addr uint64, ctl uintptr) error {
 	if reg < 0 || reg > 3 {
 		return fmt.Errorf("invalid debug register value")
 	}
@@ -29,9 +63,9 @@ func setHardwareBreakpoint(reg, tid int, addr uint64) error {
 	var (
 		dr7off    = uintptr(C.offset(C.DR_CONTROL))
 		drxoff    = uintptr(C.offset(C.int(reg)))
-		drxmask   = uintptr((((1 << C.DR_CONTROL_SIZE) - 1) << uintptr(reg*C.DR_CONTROL_SIZE)) | (((1 << C.DR_ENABLE_SIZE) - 1) << uintptr(reg*C.DR_ENABLE_SIZE)))
+		drxmask   = uintptr((((1 << C.DR_CONTROL_SIZE) - 1) << uintptr(reg*C.DR_CONTROL_SIZE+C.DR_CONTROL_SHIFT)) | (((1 << C.DR_ENABLE_SIZE) - 1) << uintptr(reg*C.DR_ENABLE_SIZE)))
 		drxenable = uintptr(0x1) << uintptr(reg*C.DR_ENABLE_SIZE)
-		drxctl    = uintptr(C.DR_RW_EXECUTE|C.DR_LEN_1) << uintptr(reg*C.DR_CONTROL_SIZE)
+		drxctl    = ctl << uintptr(reg*C.DR_CONTROL_SIZE)
 	)
 
 	// Get current state
@@ -46,13 +80,12 @@ func setHardwareBreakpoint(reg, tid int, addr uint64) error {
 		return PtracePokeUser(tid, dr7off, dr7)
 	}
 
-	// Error out if dr`reg` is already used
-	if dr7&(0x3<<uint(reg*C.DR_ENABLE_SIZE)) != 0 {
-		return fmt.Errorf("dr%d already enabled", reg)
-	}
+	// Overwrite dr`reg` even if it is enabled already. Callers keep
+	// track of the registers in use, and a cloned thread starts with
+	// the debug registers of the thread that created it.
 
 	// Set the debug register `reg` with the address of the
-	// instruction we want to trigger a debug exception.
+	// instruction or data we want to trigger a debug exception.
 	if err := PtracePokeUser(tid, drxoff, uintptr(addr)); err != nil {
 		return err
 	}
@@ -64,14 +97,44 @@ func setHardwareBreakpoint(reg, tid int, addr uint64) error {
 
 	// Set the debug control register. This
 	// instructs the cpu to raise a debug
-	// exception when hitting the address of
-	// an instruction stored in dr0-dr3.
+	// exception when accessing the address
+	// stored in dr0-dr3.
 	return PtracePokeUser(tid, dr7off, dr7)
 }
 
+// Reports whether thread tid is stopped, and so whether its debug
+// registers can be changed.
+func threadStopped(tid int) bool {
+	return stopped(tid)
+}
+
 // Clears a hardware breakpoint. Essentially sets
 // the debug reg to 0 and clears the control register
 // flags for that reg.
 func clearHardwareBreakpoint(reg, tid int) error {
-	return setHardwareBreakpoint(reg, tid, 0)
+	return setDebugRegister(reg, tid, 0, 0)
+}
+
+// Decodes the debug status register (DR6) of thread tid, returning the
+// index of the debug register that raised the last debug exception, or
+// -1 if the exception did not come from a debug register. The status
+// register is reset, as the cpu never clears it by itself.
+func hardwareBreakpointHit(tid int) (int, error) {
+	dr6off := uintptr(C.offset(C.DR_STATUS))
+	dr6, err := PtracePeekUser(tid, dr6off)
+	if err != nil {
+		return -1, err
+	}
+	if dr6&(C.DR_TRAP0|C.DR_TRAP1|C.DR_TRAP2|C.DR_TRAP3) == 0 {
+		return -1, nil
+	}
+	if err := PtracePokeUser(tid, dr6off, 0); err != nil {
+		return -1, err
+	}
+	for reg := 0; reg < 4; reg++ {
+		if dr6&(C.DR_TRAP0<<uint(reg)) != 0 {
+			return reg, nil
+		}
+	}
+	return -1, nil
 }
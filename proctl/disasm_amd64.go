@@ -0,0 +1,129 @@
+package proctl
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/arch/x86/x86asm"
+)
+
+// Returns the address of the instruction that ends right at pc, and the
+// instruction. Instructions have variable length, so the function
+// containing pc is decoded from its entry point until pc is reached.
+func (thread *ThreadContext) previousInstruction(pc uint64) (uint64, x86asm.Inst, error) {
+	// At the entry of a function the previous instruction is the CALL
+	// or jump that got there, not the one laid out before it.
+	if fn := thread.Process.goSymTable.PCToFunc(pc); fn != nil && fn.Entry == pc {
+		return 0, x86asm.Inst{}, fmt.Errorf("%#v is the entry of %s, the previous instruction is unknown", pc, fn.Name)
+	}
+	fn := thread.Process.goSymTable.PCToFunc(pc - 1)
+	if fn == nil {
+		return 0, x86asm.Inst{}, fmt.Errorf("could not find function for %#v", pc)
+	}
+
+	code := make([]byte, pc-fn.Entry)
+	if _, err := readMemory(thread, uintptr(fn.Entry), code); err != nil {
+		return 0, x86asm.Inst{}, err
+	}
+	// Hide our own software breakpoints from the decoder.
+	for addr, bp := range thread.Process.BreakPoints {
+		if addr >= fn.Entry && addr < pc {
+			code[addr-fn.Entry] = bp.OriginalData[0]
+		}
+	}
+
+	for addr := fn.Entry; addr < pc; {
+		inst, err := x86asm.Decode(code[addr-fn.Entry:], 64)
+		if err != nil {
+			return 0, x86asm.Inst{}, fmt.Errorf("could not decode instruction at %#v: %s", addr, err)
+		}
+		next := addr + uint64(inst.Len)
+		if next == pc {
+			return addr, inst, nil
+		}
+		addr = next
+	}
+	return 0, x86asm.Inst{}, fmt.Errorf("no instruction ends at %#v", pc)
+}
+
+// Returns whether inst reads memory rather than only writing it. Moves
+// to memory, SETcc, STOS, and the stack stores of PUSH and CALL only
+// write, other instructions accessing memory read it, including those
+// writing the result back.
+func readsMemory(inst x86asm.Inst) bool {
+	switch inst.Op {
+	case x86asm.PUSH, x86asm.CALL:
+		// Only a memory operand is read.
+		_, ok := inst.Args[0].(x86asm.Mem)
+		return ok
+	case x86asm.POP:
+		// Reads the stack, and writes a memory operand.
+		_, ok := inst.Args[0].(x86asm.Mem)
+		return !ok
+	case x86asm.STOSB, x86asm.STOSW, x86asm.STOSD, x86asm.STOSQ,
+		x86asm.SETA, x86asm.SETAE, x86asm.SETB, x86asm.SETBE, x86asm.SETE, x86asm.SETG,
+		x86asm.SETGE, x86asm.SETL, x86asm.SETLE, x86asm.SETNE, x86asm.SETNO, x86asm.SETNP,
+		x86asm.SETNS, x86asm.SETO, x86asm.SETP, x86asm.SETS:
+		return false
+	case x86asm.MOV, x86asm.MOVAPD, x86asm.MOVAPS, x86asm.MOVBE, x86asm.MOVD, x86asm.MOVDQA,
+		x86asm.MOVDQU, x86asm.MOVHPD, x86asm.MOVHPS, x86asm.MOVLPD, x86asm.MOVLPS, x86asm.MOVNTDQ,
+		x86asm.MOVNTI, x86asm.MOVNTPD, x86asm.MOVNTPS, x86asm.MOVNTQ, x86asm.MOVNTSD, x86asm.MOVNTSS,
+		x86asm.MOVQ, x86asm.MOVSD_XMM, x86asm.MOVSS, x86asm.MOVUPD, x86asm.MOVUPS:
+		// The destination comes first.
+		_, ok := inst.Args[1].(x86asm.Mem)
+		return ok
+	}
+	return true
+}
+
+// AsmInstruction is a machine instruction decoded from the memory of the
+// target process.
+type AsmInstruction struct {
+	Addr  uint64
+	Bytes []byte
+	// The instruction in Go assembler syntax.
+	Text string
+}
+
+// Decodes count instructions starting at addr.
+func (dbp *DebuggedProcess) Disassemble(addr uint64, count int) ([]AsmInstruction, error) {
+	// Instructions are at most 15 bytes long.
+	code, err := dbp.ReadMemory(uintptr(addr), count*15)
+	if err != nil {
+		// The end of the read may be past the end of the mapping,
+		// only read up to the end of the page holding addr.
+		pagesize := uint64(os.Getpagesize())
+		code, err = dbp.ReadMemory(uintptr(addr), int(pagesize-addr%pagesize))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	insts := make([]AsmInstruction, 0, count)
+	for off := 0; len(insts) < count && off < len(code); {
+		inst, err := x86asm.Decode(code[off:], 64)
+		if err == x86asm.ErrTruncated && len(insts) > 0 {
+			// Ran into the end of the memory read.
+			break
+		}
+		if err != nil {
+			return insts, fmt.Errorf("could not decode instruction at %#v: %s", addr+uint64(off), err)
+		}
+		pc := addr + uint64(off)
+		insts = append(insts, AsmInstruction{Addr: pc, Bytes: code[off : off+inst.Len], Text: x86asm.GoSyntax(inst, pc, dbp.symLookup)})
+		off += inst.Len
+	}
+	return insts, nil
+}
+
+// Resolves an address to the name and address of the symbol that
+// contains it, used to annotate disassembled instructions.
+func (dbp *DebuggedProcess) symLookup(addr uint64) (string, uint64) {
+	if fn := dbp.goSymTable.PCToFunc(addr); fn != nil {
+		return fn.Name, fn.Entry
+	}
+	if sym := dbp.goSymTable.SymByAddr(addr); sym != nil {
+		return sym.Name, sym.Value
+	}
+	return "", 0
+}
@@ -0,0 +1,1268 @@
+package proctl
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"encoding/binary"
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/parser"
+	"go/printer"
+	"go/scanner"
+	"go/token"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Evaluates the Go expression expr in the context of the selected
+// frame of the current thread.
+func (dbp *DebuggedProcess) EvalExpression(expr string, cfg LoadConfig) (*Variable, error) {
+	return dbp.CurrentThread.EvalExpression(expr, cfg)
+}
+
+// Parses the Go expression expr as EvalExpression does, for use as the
+// condition of a breakpoint.
+func (dbp *DebuggedProcess) ParseExpr(expr string) (ast.Expr, error) {
+	return dbp.CurrentThread.parseExpr(expr)
+}
+
+// Evaluates the Go expression expr in the context of the selected frame
+// of this thread. Supported are variables and chains of field selectors,
+// indexing and slicing, pointer dereferences and address-of operations,
+// arithmetic, comparisons, boolean logic, conversions and type
+// assertions. cfg limits how much of the result is loaded.
+func (thread *ThreadContext) EvalExpression(expr string, cfg LoadConfig) (*Variable, error) {
+	t, err := thread.parseExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+	v, err := thread.evalAST(t)
+	if err != nil {
+		return nil, err
+	}
+	if err := thread.loadValue(v, cfg); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Parses the Go expression expr. Package paths, which Go syntax does not
+// allow in expressions, are quoted first so that net/http.DefaultClient
+// parses as the selector "net/http".DefaultClient.
+func (thread *ThreadContext) parseExpr(expr string) (ast.Expr, error) {
+	if strings.Contains(expr, "/") {
+		expr = quotePackagePaths(expr, thread.Process.packagePaths())
+	}
+	return parser.ParseExpr(expr)
+}
+
+// Quotes the package paths in expr that are in packages. Only paths of
+// known packages are quoted, to tell them apart from divisions.
+func quotePackagePaths(expr string, packages map[string]bool) string {
+	var s scanner.Scanner
+	fset := token.NewFileSet()
+	s.Init(fset.AddFile("", fset.Base(), len(expr)), []byte(expr), nil, 0)
+
+	// A package path is a run of identifiers separated by slashes, dots
+	// and dashes without spaces in between, followed by a selector.
+	var out bytes.Buffer
+	start, end, last := -1, 0, 0
+	flush := func() {
+		if start < 0 {
+			return
+		}
+		run := expr[start:end]
+		if slash := strings.LastIndex(run, "/"); slash >= 0 {
+			if dot := strings.Index(run[slash:], "."); dot >= 0 && packages[run[:slash+dot]] {
+				out.WriteString(expr[last:start])
+				out.WriteString(strconv.Quote(run[:slash+dot]))
+				last = start + slash + dot
+			}
+		}
+		start = -1
+	}
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		off := fset.Position(pos).Offset
+		switch tok {
+		case token.IDENT, token.PERIOD, token.QUO, token.SUB:
+			if start >= 0 && off != end {
+				flush()
+			}
+			if start < 0 && tok != token.IDENT {
+				continue
+			}
+			if start < 0 {
+				start = off
+			}
+			if tok == token.IDENT {
+				end = off + len(lit)
+			} else {
+				end = off + len(tok.String())
+			}
+		default:
+			flush()
+		}
+	}
+	flush()
+	out.WriteString(expr[last:])
+	return out.String()
+}
+
+// Returns the paths of the packages that have variables.
+func (dbp *DebuggedProcess) packagePaths() map[string]bool {
+	dbp.indexPackageVariables()
+	return dbp.packages
+}
+
+// Indexes the package variables by their fully qualified name, and the
+// packages they belong to by path. The index is built once per process.
+func (dbp *DebuggedProcess) indexPackageVariables() {
+	if dbp.packageVars != nil {
+		return
+	}
+	dbp.packageVars = make(map[string]dwarf.Offset)
+	dbp.packages = make(map[string]bool)
+	reader := dbp.DwarfReader()
+	for entry, err := reader.NextPackageVariable(); entry != nil && err == nil; entry, err = reader.NextPackageVariable() {
+		name, ok := entry.Val(dwarf.AttrName).(string)
+		if !ok {
+			continue
+		}
+		dbp.packageVars[name] = entry.Offset
+		i := strings.LastIndex(name, "/") + 1
+		if dot := strings.Index(name[i:], "."); dot >= 0 {
+			dbp.packages[name[:i+dot]] = true
+		}
+	}
+}
+
+// Evaluates expr, which must produce a boolean, in the context of the
+// selected frame of this thread. Used for breakpoint conditions.
+func (thread *ThreadContext) evalBool(expr ast.Expr) (bool, error) {
+	v, err := thread.evalAST(expr)
+	if err != nil {
+		return false, err
+	}
+	c, err := thread.variableConstant(v)
+	if err != nil {
+		return false, err
+	}
+	if c.Kind() != constant.Bool {
+		return false, fmt.Errorf("expression %s does not evaluate to a boolean", exprToString(expr))
+	}
+	return constant.BoolVal(c), nil
+}
+
+// Assigns the value of the Go expression value to the variable described
+// by name in the selected frame of the current thread.
+func (dbp *DebuggedProcess) SetVariable(name, value string) error {
+	return dbp.CurrentThread.SetVariable(name, value)
+}
+
+// Assigns the value of the Go expression value to the variable described
+// by name, which may be a struct member, slice element or dereferenced
+// pointer. Numbers, bools and pointers can be assigned constants, any
+// variable can be assigned another variable of the same type.
+func (thread *ThreadContext) SetVariable(name, value string) error {
+	lexpr, err := thread.parseExpr(name)
+	if err != nil {
+		return err
+	}
+	dst, err := thread.evalAST(lexpr)
+	if err != nil {
+		return err
+	}
+	if !dst.inMemory() {
+		return fmt.Errorf("can not assign to %s", dst.Name)
+	}
+
+	rexpr, err := thread.parseExpr(value)
+	if err != nil {
+		return err
+	}
+	src, err := thread.evalAST(rexpr)
+	if err != nil {
+		return err
+	}
+
+	data, err := thread.assignmentData(dst, src)
+	if err != nil {
+		return err
+	}
+	_, err = writeMemory(thread, dst.Addr, data)
+	return err
+}
+
+// Returns the bytes to write to dst when assigning src to it.
+func (thread *ThreadContext) assignmentData(dst, src *Variable) ([]byte, error) {
+	// Variables of the same type are copied verbatim.
+	if src.addressable() {
+		if src.dwarfType.String() != dst.dwarfType.String() {
+			return nil, fmt.Errorf("can not assign %s (type %s) to %s (type %s)", src.Name, src.typeString(), dst.Name, dst.typeString())
+		}
+		return thread.readVariable(src, 0, uintptr(dst.dwarfType.Size()))
+	}
+
+	c, err := thread.variableConstant(src)
+	if err != nil {
+		return nil, err
+	}
+	if src.dwarfType != nil && src.dwarfType.String() != dst.dwarfType.String() {
+		return nil, fmt.Errorf("can not assign %s (type %s) to %s (type %s)", src.Name, src.typeString(), dst.Name, dst.typeString())
+	}
+
+	data := make([]byte, 8)
+	switch t := resolveTypedef(dst.dwarfType).(type) {
+	case *dwarf.IntType, *dwarf.UintType:
+		if c.Kind() != constant.Int {
+			return nil, fmt.Errorf("can not assign %s to %s (type %s)", src.Name, dst.Name, dst.typeString())
+		}
+		v, err := typedConstant(c, t)
+		if err != nil {
+			return nil, err
+		}
+		if constant.Compare(v.ConstValue, token.NEQ, c) {
+			return nil, fmt.Errorf("constant %s overflows %s", c.ExactString(), dst.typeString())
+		}
+		if n, exact := constant.Int64Val(v.ConstValue); exact {
+			binary.LittleEndian.PutUint64(data, uint64(n))
+		} else {
+			n, _ := constant.Uint64Val(v.ConstValue)
+			binary.LittleEndian.PutUint64(data, n)
+		}
+		return data[:t.Size()], nil
+
+	case *dwarf.FloatType:
+		if c.Kind() != constant.Int && c.Kind() != constant.Float {
+			return nil, fmt.Errorf("can not assign %s to %s (type %s)", src.Name, dst.Name, dst.typeString())
+		}
+		f, _ := constant.Float64Val(constant.ToFloat(c))
+		if t.ByteSize == 4 {
+			binary.LittleEndian.PutUint32(data, math.Float32bits(float32(f)))
+		} else {
+			binary.LittleEndian.PutUint64(data, math.Float64bits(f))
+		}
+		return data[:t.ByteSize], nil
+
+	case *dwarf.ComplexType:
+		if c.Kind() != constant.Int && c.Kind() != constant.Float && c.Kind() != constant.Complex {
+			return nil, fmt.Errorf("can not assign %s to %s (type %s)", src.Name, dst.Name, dst.typeString())
+		}
+		re, _ := constant.Float64Val(constant.ToFloat(constant.Real(c)))
+		im, _ := constant.Float64Val(constant.ToFloat(constant.Imag(c)))
+		data = make([]byte, 16)
+		if t.ByteSize == 8 {
+			binary.LittleEndian.PutUint32(data, math.Float32bits(float32(re)))
+			binary.LittleEndian.PutUint32(data[4:], math.Float32bits(float32(im)))
+		} else {
+			binary.LittleEndian.PutUint64(data, math.Float64bits(re))
+			binary.LittleEndian.PutUint64(data[8:], math.Float64bits(im))
+		}
+		return data[:t.ByteSize], nil
+
+	case *dwarf.BoolType:
+		if c.Kind() != constant.Bool {
+			return nil, fmt.Errorf("can not assign %s to %s (type %s)", src.Name, dst.Name, dst.typeString())
+		}
+		if constant.BoolVal(c) {
+			return []byte{1}, nil
+		}
+		return []byte{0}, nil
+
+	case *dwarf.PtrType:
+		if c.Kind() != constant.Int {
+			return nil, fmt.Errorf("can not assign %s to %s (type %s)", src.Name, dst.Name, dst.typeString())
+		}
+		addr, _ := constant.Uint64Val(c)
+		binary.LittleEndian.PutUint64(data, addr)
+		return data[:ptrsize], nil
+	}
+	return nil, fmt.Errorf("can not assign %s to %s (type %s)", src.Name, dst.Name, dst.typeString())
+}
+
+// Evaluates expr down to a variable, without loading its value. The
+// result either lives in the memory of the target or, when it was
+// computed by the evaluator, holds a constant or a slice header.
+func (thread *ThreadContext) evalAST(expr ast.Expr) (*Variable, error) {
+	v, err := thread.evalNode(expr)
+	if err != nil {
+		return nil, err
+	}
+	v.Name = exprToString(expr)
+	return v, nil
+}
+
+func (thread *ThreadContext) evalNode(expr ast.Expr) (*Variable, error) {
+	switch node := expr.(type) {
+	case *ast.ParenExpr:
+		return thread.evalAST(node.X)
+
+	case *ast.BasicLit:
+		c := constant.MakeFromLiteral(node.Value, node.Kind, 0)
+		if c.Kind() == constant.Unknown {
+			return nil, fmt.Errorf("invalid literal %s", node.Value)
+		}
+		return newConstant(c, nil), nil
+
+	case *ast.Ident:
+		switch node.Name {
+		case "true", "false":
+			return newConstant(constant.MakeBool(node.Name == "true"), nil), nil
+		case "nil":
+			return newConstant(constant.MakeUint64(0), nil), nil
+		}
+		return thread.findVariable(node.Name)
+
+	case *ast.SelectorExpr:
+		return thread.evalSelector(node)
+
+	case *ast.StarExpr:
+		x, err := thread.evalAST(node.X)
+		if err != nil {
+			return nil, err
+		}
+		return thread.deref(x)
+
+	case *ast.UnaryExpr:
+		return thread.evalUnary(node)
+
+	case *ast.BinaryExpr:
+		return thread.evalBinary(node)
+
+	case *ast.IndexExpr:
+		return thread.evalIndex(node)
+
+	case *ast.SliceExpr:
+		return thread.evalSlice(node)
+
+	case *ast.CallExpr:
+		return thread.evalConversion(node)
+
+	case *ast.TypeAssertExpr:
+		return thread.evalTypeAssert(node)
+	}
+
+	return nil, fmt.Errorf("expression %s not supported", exprToString(expr))
+}
+
+// Returns the variable called name that is visible from the selected
+// frame, without loading its value.
+func (thread *ThreadContext) scopeVariable(name string) (*Variable, error) {
+	vars, err := thread.scopeEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	// The declaration in the innermost block shadows the others.
+	found := -1
+	for i := range vars {
+		n, ok := vars[i].Val(dwarf.AttrName).(string)
+		if !ok || n != name {
+			continue
+		}
+		if found < 0 || vars[i].Depth >= vars[found].Depth {
+			found = i
+		}
+	}
+	if found < 0 {
+		return nil, fmt.Errorf("could not find symbol value for %s", name)
+	}
+	return thread.entryVariable(vars[found].Entry)
+}
+
+// Returns the variable called name in the scope of the selected frame or,
+// failing that, in the package of the frame's function.
+func (thread *ThreadContext) findVariable(name string) (*Variable, error) {
+	v, err := thread.scopeVariable(name)
+	if err == nil {
+		return v, nil
+	}
+	frame, ferr := thread.currentFrame()
+	if ferr != nil || frame.Fn == nil {
+		return nil, err
+	}
+	if v, perr := thread.packageVariable(frame.Fn.PackageName() + "." + name); perr == nil {
+		return v, nil
+	}
+	return nil, err
+}
+
+// Returns the package variable with the fully qualified name name, such
+// as net/http.DefaultClient.
+func (thread *ThreadContext) packageVariable(name string) (*Variable, error) {
+	dbp := thread.Process
+	dbp.indexPackageVariables()
+	off, ok := dbp.packageVars[name]
+	if !ok {
+		return nil, fmt.Errorf("could not find symbol value for %s", name)
+	}
+	reader := dbp.dwarf.Reader()
+	reader.Seek(off)
+	entry, err := reader.Next()
+	if err != nil {
+		return nil, err
+	}
+	return thread.entryVariable(entry)
+}
+
+func (thread *ThreadContext) evalSelector(node *ast.SelectorExpr) (*Variable, error) {
+	// Selectors on package names are package variables, unless a local
+	// variable shadows the package.
+	switch x := node.X.(type) {
+	case *ast.BasicLit:
+		if x.Kind == token.STRING {
+			path, err := strconv.Unquote(x.Value)
+			if err != nil {
+				return nil, err
+			}
+			return thread.packageVariable(path + "." + node.Sel.Name)
+		}
+	case *ast.Ident:
+		if thread.Process.packagePaths()[x.Name] {
+			if _, err := thread.scopeVariable(x.Name); err != nil {
+				return thread.packageVariable(x.Name + "." + node.Sel.Name)
+			}
+		}
+	}
+
+	x, err := thread.evalAST(node.X)
+	if err != nil {
+		return nil, err
+	}
+
+	// Members of interfaces are those of their dynamic value.
+	if _, ok := interfaceHeader(x.dwarfType); ok {
+		if x, err = thread.dynamicValue(x); err != nil {
+			return nil, err
+		}
+	}
+
+	// Like Go, dereference pointers to structs automatically.
+	typ := resolveTypedef(x.dwarfType)
+	ptr, isptr := typ.(*dwarf.PtrType)
+	if isptr {
+		typ = resolveTypedef(ptr.Type)
+	}
+	st, ok := typ.(*dwarf.StructType)
+	if !ok || (!isptr && !x.addressable()) {
+		return nil, fmt.Errorf("%s (type %s) has no members", x.Name, x.typeString())
+	}
+
+	for _, field := range st.Field {
+		if field.Name != node.Sel.Name {
+			continue
+		}
+		// Wait until here to report nil pointers, a missing
+		// member is the more useful error.
+		if isptr {
+			if x, err = thread.deref(x); err != nil {
+				return nil, err
+			}
+		}
+		return x.part("", uintptr(field.ByteOffset), field.Type), nil
+	}
+	return nil, fmt.Errorf("%s has no member %s", x.Name, node.Sel.Name)
+}
+
+// Returns the dynamic value of the interface x.
+func (thread *ThreadContext) dynamicValue(x *Variable) (*Variable, error) {
+	st, ok := interfaceHeader(x.dwarfType)
+	if !ok || !x.addressable() {
+		return nil, fmt.Errorf("%s (type %s) is not an interface", x.Name, x.typeString())
+	}
+	v, err := thread.interfaceValue(x, st)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, fmt.Errorf("%s is nil", x.Name)
+	}
+	v.Name = x.Name
+	return v, nil
+}
+
+func (thread *ThreadContext) evalTypeAssert(node *ast.TypeAssertExpr) (*Variable, error) {
+	if node.Type == nil {
+		return nil, fmt.Errorf("expression %s not supported", exprToString(node))
+	}
+	x, err := thread.evalAST(node.X)
+	if err != nil {
+		return nil, err
+	}
+	v, err := thread.dynamicValue(x)
+	if err != nil {
+		return nil, err
+	}
+	want := exprToString(node.Type)
+	if v.dwarfType.String() != want {
+		return nil, fmt.Errorf("interface conversion: %s is %s, not %s", x.Name, v.dwarfType, want)
+	}
+	return v, nil
+}
+
+// Returns the variable pointed to by x.
+func (thread *ThreadContext) deref(x *Variable) (*Variable, error) {
+	ptr, ok := resolveTypedef(x.dwarfType).(*dwarf.PtrType)
+	if !ok {
+		return nil, fmt.Errorf("%s (type %s) is not a pointer", x.Name, x.typeString())
+	}
+	addr, err := thread.pointerValue(x)
+	if err != nil {
+		return nil, err
+	}
+	if addr == 0 {
+		return nil, fmt.Errorf("%s is nil", x.Name)
+	}
+	return &Variable{Addr: uintptr(addr), dwarfType: ptr.Type}, nil
+}
+
+// Returns the address stored in the pointer x.
+func (thread *ThreadContext) pointerValue(x *Variable) (uint64, error) {
+	if x.ConstValue != nil {
+		addr, _ := constant.Uint64Val(x.ConstValue)
+		return addr, nil
+	}
+	return thread.readPointer(x)
+}
+
+func (thread *ThreadContext) evalUnary(node *ast.UnaryExpr) (*Variable, error) {
+	x, err := thread.evalAST(node.X)
+	if err != nil {
+		return nil, err
+	}
+
+	if node.Op == token.AND {
+		if !x.inMemory() {
+			return nil, fmt.Errorf("can not take the address of %s", x.Name)
+		}
+		return newConstant(constant.MakeUint64(uint64(x.Addr)), pointerTo(x.dwarfType)), nil
+	}
+
+	c, err := thread.variableConstant(x)
+	if err != nil {
+		return nil, err
+	}
+	var prec uint
+	switch node.Op {
+	case token.NOT:
+		if c.Kind() != constant.Bool {
+			return nil, fmt.Errorf("operator ! not defined on %s", x.Name)
+		}
+	case token.SUB, token.ADD:
+		if c.Kind() != constant.Int && c.Kind() != constant.Float {
+			return nil, fmt.Errorf("operator %s not defined on %s", node.Op, x.Name)
+		}
+	case token.XOR:
+		if c.Kind() != constant.Int {
+			return nil, fmt.Errorf("operator %s not defined on %s", node.Op, x.Name)
+		}
+		// The complement of unsigned values depends on their size.
+		if t, ok := resolveTypedef(x.dwarfType).(*dwarf.UintType); ok {
+			prec = uint(t.ByteSize * 8)
+		}
+	default:
+		return nil, fmt.Errorf("operator %s not supported", node.Op)
+	}
+	return typedConstant(constant.UnaryOp(node.Op, c, prec), x.dwarfType)
+}
+
+func (thread *ThreadContext) evalBinary(node *ast.BinaryExpr) (*Variable, error) {
+	x, err := thread.evalAST(node.X)
+	if err != nil {
+		return nil, err
+	}
+	xc, err := thread.variableConstant(x)
+	if err != nil {
+		return nil, err
+	}
+
+	// Short circuit boolean operators like Go does.
+	if node.Op == token.LAND || node.Op == token.LOR {
+		if xc.Kind() != constant.Bool {
+			return nil, fmt.Errorf("operator %s not defined on %s", node.Op, x.Name)
+		}
+		if constant.BoolVal(xc) == (node.Op == token.LOR) {
+			return newConstant(xc, nil), nil
+		}
+		y, err := thread.evalAST(node.Y)
+		if err != nil {
+			return nil, err
+		}
+		yc, err := thread.variableConstant(y)
+		if err != nil {
+			return nil, err
+		}
+		if yc.Kind() != constant.Bool {
+			return nil, fmt.Errorf("operator %s not defined on %s", node.Op, y.Name)
+		}
+		return newConstant(yc, nil), nil
+	}
+
+	y, err := thread.evalAST(node.Y)
+	if err != nil {
+		return nil, err
+	}
+	yc, err := thread.variableConstant(y)
+	if err != nil {
+		return nil, err
+	}
+
+	if node.Op == token.SHL || node.Op == token.SHR {
+		if xc.Kind() != constant.Int || yc.Kind() != constant.Int {
+			return nil, fmt.Errorf("invalid shift %s", exprToString(node))
+		}
+		s, ok := constant.Uint64Val(yc)
+		if !ok {
+			return nil, fmt.Errorf("invalid shift count %s", y.Name)
+		}
+		return typedConstant(constant.Shift(xc, node.Op, uint(s)), x.dwarfType)
+	}
+
+	if !compatibleConstants(xc, yc) {
+		return nil, fmt.Errorf("mismatched types in %s", exprToString(node))
+	}
+	typ := x.dwarfType
+	if typ == nil {
+		typ = y.dwarfType
+	} else if y.dwarfType != nil && x.dwarfType.String() != y.dwarfType.String() {
+		return nil, fmt.Errorf("mismatched types %s and %s in %s", x.dwarfType, y.dwarfType, exprToString(node))
+	}
+
+	switch node.Op {
+	case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+		if node.Op != token.EQL && node.Op != token.NEQ {
+			switch {
+			case xc.Kind() == constant.Bool:
+				return nil, fmt.Errorf("operator %s not defined on bool", node.Op)
+			case xc.Kind() == constant.Complex || yc.Kind() == constant.Complex:
+				return nil, fmt.Errorf("operator %s not defined on complex", node.Op)
+			}
+		}
+		return newConstant(constant.MakeBool(constant.Compare(xc, node.Op, yc)), nil), nil
+
+	case token.ADD, token.SUB, token.MUL, token.QUO, token.REM, token.AND, token.OR, token.XOR, token.AND_NOT:
+		if _, ok := resolveTypedef(typ).(*dwarf.PtrType); ok {
+			return nil, fmt.Errorf("operator %s not defined on pointers", node.Op)
+		}
+		switch xc.Kind() {
+		case constant.Bool:
+			return nil, fmt.Errorf("operator %s not defined on bool", node.Op)
+		case constant.String:
+			if node.Op != token.ADD {
+				return nil, fmt.Errorf("operator %s not defined on strings", node.Op)
+			}
+		}
+		op := node.Op
+		switch op {
+		case token.QUO, token.REM:
+			if yc.Kind() != constant.String && constant.Sign(yc) == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+		}
+		if op != token.ADD && op != token.SUB && op != token.MUL && op != token.QUO {
+			if xc.Kind() != constant.Int || yc.Kind() != constant.Int {
+				return nil, fmt.Errorf("operator %s not defined on %s", op, exprToString(node))
+			}
+		}
+		// Integer division truncates, unless one of the operands is a float.
+		if op == token.QUO && xc.Kind() == constant.Int && yc.Kind() == constant.Int {
+			op = token.QUO_ASSIGN
+		}
+		return typedConstant(constant.BinaryOp(xc, op, yc), typ)
+	}
+
+	return nil, fmt.Errorf("operator %s not supported", node.Op)
+}
+
+func (thread *ThreadContext) evalIndex(node *ast.IndexExpr) (*Variable, error) {
+	x, err := thread.evalAST(node.X)
+	if err != nil {
+		return nil, err
+	}
+	if x, err = thread.derefArrayPointer(x); err != nil {
+		return nil, err
+	}
+	idx, err := thread.evalAST(node.Index)
+	if err != nil {
+		return nil, err
+	}
+	if hmap, ok := mapHeader(x.dwarfType); ok {
+		return thread.mapIndex(x, hmap, idx)
+	}
+	n, err := thread.intValue(idx)
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := resolveTypedef(x.dwarfType).(type) {
+	case *dwarf.ArrayType:
+		if n < 0 || n >= t.Count {
+			return nil, fmt.Errorf("index %d out of range [0:%d]", n, t.Count)
+		}
+		return x.part("", uintptr(n*(t.ByteSize/t.Count)), t.Type), nil
+
+	case *dwarf.StructType:
+		if t.StructName == "string" {
+			c, err := thread.variableConstant(x)
+			if err != nil {
+				return nil, err
+			}
+			s := constant.StringVal(c)
+			if n < 0 || n >= int64(len(s)) {
+				return nil, fmt.Errorf("index %d out of range [0:%d]", n, len(s))
+			}
+			byteType, err := thread.Process.findType("uint8")
+			if err != nil {
+				return nil, err
+			}
+			return newConstant(constant.MakeUint64(uint64(s[n])), byteType), nil
+		}
+		if strings.HasPrefix(t.StructName, "[]") {
+			base, length, _, elem, err := thread.sliceHeader(x)
+			if err != nil {
+				return nil, err
+			}
+			if n < 0 || n >= length {
+				return nil, fmt.Errorf("index %d out of range [0:%d]", n, length)
+			}
+			return &Variable{Addr: base + uintptr(n*typeSize(elem)), dwarfType: elem}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%s (type %s) can not be indexed", x.Name, x.typeString())
+}
+
+// Looks up key in the map x. Only keys of basic types are supported.
+func (thread *ThreadContext) mapIndex(x *Variable, hmap *dwarf.StructType, key *Variable) (*Variable, error) {
+	l, err := mapLayoutFor(hmap)
+	if err != nil {
+		return nil, err
+	}
+	kc, err := thread.variableConstant(key)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := thread.pointerValue(x)
+	if err != nil {
+		return nil, err
+	}
+
+	var found *Variable
+	if addr != 0 {
+		_, err = thread.mapIterate(uintptr(addr), l, func(k, v uintptr) (bool, error) {
+			c, err := thread.variableConstant(&Variable{Name: "key of " + x.Name, Addr: k, dwarfType: l.keyType})
+			if err != nil {
+				return false, err
+			}
+			if compatibleConstants(c, kc) && constant.Compare(c, token.EQL, kc) {
+				found = &Variable{Addr: v, dwarfType: l.valueType}
+				return false, nil
+			}
+			return true, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("key %s not found in %s", key.Name, x.Name)
+	}
+	return found, nil
+}
+
+func (thread *ThreadContext) evalSlice(node *ast.SliceExpr) (*Variable, error) {
+	x, err := thread.evalAST(node.X)
+	if err != nil {
+		return nil, err
+	}
+	if x, err = thread.derefArrayPointer(x); err != nil {
+		return nil, err
+	}
+
+	bound := func(expr ast.Expr, def int64) (int64, error) {
+		if expr == nil {
+			return def, nil
+		}
+		v, err := thread.evalAST(expr)
+		if err != nil {
+			return 0, err
+		}
+		return thread.intValue(v)
+	}
+
+	var (
+		base        uintptr
+		length, cap int64
+		elem        dwarf.Type
+		typ         dwarf.Type
+	)
+	switch t := resolveTypedef(x.dwarfType).(type) {
+	case *dwarf.ArrayType:
+		if !x.inMemory() {
+			return nil, fmt.Errorf("can not slice %s, it is not addressable", x.Name)
+		}
+		base, length, cap, elem = x.Addr, t.Count, t.Count, t.Type
+		typ = sliceOf(elem)
+
+	case *dwarf.StructType:
+		if t.StructName == "string" {
+			if node.Slice3 {
+				return nil, fmt.Errorf("3-index slice of string")
+			}
+			c, err := thread.variableConstant(x)
+			if err != nil {
+				return nil, err
+			}
+			s := constant.StringVal(c)
+			lo, err := bound(node.Low, 0)
+			if err != nil {
+				return nil, err
+			}
+			hi, err := bound(node.High, int64(len(s)))
+			if err != nil {
+				return nil, err
+			}
+			if lo < 0 || hi < lo || hi > int64(len(s)) {
+				return nil, fmt.Errorf("slice bounds out of range [%d:%d] with length %d", lo, hi, len(s))
+			}
+			return newConstant(constant.MakeString(s[lo:hi]), x.dwarfType), nil
+		}
+		if !strings.HasPrefix(t.StructName, "[]") {
+			return nil, fmt.Errorf("%s (type %s) can not be sliced", x.Name, x.typeString())
+		}
+		if base, length, cap, elem, err = thread.sliceHeader(x); err != nil {
+			return nil, err
+		}
+		typ = x.dwarfType
+
+	default:
+		return nil, fmt.Errorf("%s (type %s) can not be sliced", x.Name, x.typeString())
+	}
+
+	lo, err := bound(node.Low, 0)
+	if err != nil {
+		return nil, err
+	}
+	hi, err := bound(node.High, length)
+	if err != nil {
+		return nil, err
+	}
+	max, err := bound(node.Max, cap)
+	if err != nil {
+		return nil, err
+	}
+	if lo < 0 || hi < lo || max < hi || max > cap {
+		return nil, fmt.Errorf("slice bounds out of range [%d:%d:%d] with capacity %d", lo, hi, max, cap)
+	}
+
+	return &Variable{dwarfType: typ, base: base + uintptr(lo*typeSize(elem)), Len: hi - lo, Cap: max - lo}, nil
+}
+
+// Dereferences x if it is a pointer to an array, which Go lets
+// programs index and slice directly.
+func (thread *ThreadContext) derefArrayPointer(x *Variable) (*Variable, error) {
+	ptr, ok := resolveTypedef(x.dwarfType).(*dwarf.PtrType)
+	if !ok {
+		return x, nil
+	}
+	if _, ok := resolveTypedef(ptr.Type).(*dwarf.ArrayType); !ok {
+		return x, nil
+	}
+	return thread.deref(x)
+}
+
+// Returns the header of the slice x.
+func (thread *ThreadContext) sliceHeader(x *Variable) (uintptr, int64, int64, dwarf.Type, error) {
+	t := resolveTypedef(x.dwarfType).(*dwarf.StructType)
+	if x.addressable() {
+		return thread.readSliceHeader(x, t)
+	}
+	for _, f := range t.Field {
+		if f.Name == "array" {
+			if ptr, ok := f.Type.(*dwarf.PtrType); ok {
+				return x.base, x.Len, x.Cap, ptr.Type, nil
+			}
+		}
+	}
+	return 0, 0, 0, nil, fmt.Errorf("invalid slice type %s", t)
+}
+
+// Handles conversions, the only kind of call expression supported.
+func (thread *ThreadContext) evalConversion(node *ast.CallExpr) (*Variable, error) {
+	fun := node.Fun
+	for {
+		if p, ok := fun.(*ast.ParenExpr); ok {
+			fun = p.X
+		} else {
+			break
+		}
+	}
+	typename := exprToString(fun)
+	typ, err := thread.Process.findType(typename)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a type, function calls are not supported", typename)
+	}
+	if len(node.Args) != 1 {
+		return nil, fmt.Errorf("wrong number of arguments in conversion to %s", typename)
+	}
+
+	x, err := thread.evalAST(node.Args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	switch resolveTypedef(typ).(type) {
+	case *dwarf.IntType, *dwarf.UintType, *dwarf.FloatType:
+		c, err := thread.variableConstant(x)
+		if err != nil {
+			return nil, err
+		}
+		if c.Kind() != constant.Int && c.Kind() != constant.Float {
+			return nil, fmt.Errorf("can not convert %s to %s", x.Name, typename)
+		}
+		return typedConstant(c, typ)
+
+	case *dwarf.PtrType:
+		c, err := thread.variableConstant(x)
+		if err != nil {
+			return nil, err
+		}
+		if c.Kind() != constant.Int {
+			return nil, fmt.Errorf("can not convert %s to %s", x.Name, typename)
+		}
+		return newConstant(c, typ), nil
+	}
+
+	// Anything else reinterprets the memory of x as the new type.
+	if x.addressable() && x.dwarfType.Size() == typ.Size() {
+		return &Variable{Addr: x.Addr, data: x.data, dwarfType: typ}, nil
+	}
+	return nil, fmt.Errorf("can not convert %s to %s", x.Name, typename)
+}
+
+// Returns the type called name in the debug information. Predeclared
+// types the program does not use are synthesized. Types are looked up
+// once per process.
+func (dbp *DebuggedProcess) findType(name string) (dwarf.Type, error) {
+	switch name {
+	case "byte":
+		name = "uint8"
+	case "rune":
+		name = "int32"
+	}
+	if t, ok := dbp.types[name]; ok {
+		return t, nil
+	}
+	t, err := dbp.lookupType(name)
+	if err != nil {
+		return nil, err
+	}
+	if dbp.types == nil {
+		dbp.types = make(map[string]dwarf.Type)
+	}
+	dbp.types[name] = t
+	return t, nil
+}
+
+func (dbp *DebuggedProcess) lookupType(name string) (dwarf.Type, error) {
+	reader := dbp.dwarf.Reader()
+	for entry, err := reader.Next(); entry != nil; entry, err = reader.Next() {
+		if err != nil {
+			return nil, err
+		}
+		switch entry.Tag {
+		case dwarf.TagBaseType, dwarf.TagTypedef, dwarf.TagStructType, dwarf.TagPointerType, dwarf.TagArrayType, dwarf.TagSubroutineType:
+		default:
+			continue
+		}
+		if n, _ := entry.Val(dwarf.AttrName).(string); n == name {
+			return dbp.dwarf.Type(entry.Offset)
+		}
+	}
+
+	basic := dwarf.BasicType{CommonType: dwarf.CommonType{Name: name}}
+	switch name {
+	case "int8", "int16", "int32", "int64":
+		basic.ByteSize, _ = strconv.ParseInt(name[3:], 10, 64)
+		basic.ByteSize /= 8
+		return &dwarf.IntType{BasicType: basic}, nil
+	case "uint8", "uint16", "uint32", "uint64":
+		basic.ByteSize, _ = strconv.ParseInt(name[4:], 10, 64)
+		basic.ByteSize /= 8
+		return &dwarf.UintType{BasicType: basic}, nil
+	case "int":
+		basic.ByteSize = int64(ptrsize)
+		return &dwarf.IntType{BasicType: basic}, nil
+	case "uint", "uintptr":
+		basic.ByteSize = int64(ptrsize)
+		return &dwarf.UintType{BasicType: basic}, nil
+	case "float32", "float64":
+		basic.ByteSize = 4
+		if name == "float64" {
+			basic.ByteSize = 8
+		}
+		return &dwarf.FloatType{BasicType: basic}, nil
+	case "bool":
+		basic.ByteSize = 1
+		return &dwarf.BoolType{BasicType: basic}, nil
+	}
+	return nil, fmt.Errorf("could not find type %s", name)
+}
+
+// Returns a type describing pointers to t.
+func pointerTo(t dwarf.Type) dwarf.Type {
+	return &dwarf.PtrType{CommonType: dwarf.CommonType{ByteSize: int64(ptrsize)}, Type: t}
+}
+
+// Returns a type describing slices of elem, laid out the way the
+// runtime lays out slice headers.
+func sliceOf(elem dwarf.Type) dwarf.Type {
+	name := "[]" + elem.String()
+	intType := &dwarf.IntType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: int64(ptrsize), Name: "int"}}}
+	return &dwarf.StructType{
+		CommonType: dwarf.CommonType{ByteSize: 3 * int64(ptrsize), Name: name},
+		StructName: name,
+		Kind:       "struct",
+		Field: []*dwarf.StructField{
+			{Name: "array", Type: pointerTo(elem), ByteOffset: 0},
+			{Name: "len", Type: intType, ByteOffset: int64(ptrsize)},
+			{Name: "cap", Type: intType, ByteOffset: 2 * int64(ptrsize)},
+		},
+	}
+}
+
+func newConstant(c constant.Value, t dwarf.Type) *Variable {
+	return &Variable{ConstValue: c, dwarfType: t}
+}
+
+// Returns a variable holding c converted to the type t. Integers wrap
+// around and floats are rounded like they are in the target.
+func typedConstant(c constant.Value, t dwarf.Type) (*Variable, error) {
+	var size int64
+	signed := false
+	switch rt := resolveTypedef(t).(type) {
+	case *dwarf.IntType:
+		size, signed = rt.ByteSize, true
+	case *dwarf.UintType:
+		size = rt.ByteSize
+	case *dwarf.FloatType:
+		c = constant.ToFloat(c)
+		if c.Kind() != constant.Float {
+			return nil, fmt.Errorf("can not convert to %s", t)
+		}
+		if rt.ByteSize == 4 {
+			f, _ := constant.Float64Val(c)
+			c = constant.MakeFloat64(float64(float32(f)))
+		}
+		return newConstant(c, t), nil
+	default:
+		return newConstant(c, t), nil
+	}
+
+	if c.Kind() == constant.Float {
+		f, _ := constant.Float64Val(c)
+		c = constant.MakeInt64(int64(f))
+	}
+	if c.Kind() != constant.Int {
+		return nil, fmt.Errorf("can not convert to %s", t)
+	}
+	bits := uint(size * 8)
+	one := constant.MakeInt64(1)
+	mask := constant.BinaryOp(constant.Shift(one, token.SHL, bits), token.SUB, one)
+	c = constant.BinaryOp(c, token.AND, mask)
+	if signed && constant.Compare(c, token.GEQ, constant.Shift(one, token.SHL, bits-1)) {
+		c = constant.BinaryOp(c, token.SUB, constant.Shift(one, token.SHL, bits))
+	}
+	return newConstant(c, t), nil
+}
+
+// Returns whether x and y may be used as operands of the same operator.
+func compatibleConstants(x, y constant.Value) bool {
+	numeric := func(v constant.Value) bool {
+		return v.Kind() == constant.Int || v.Kind() == constant.Float || v.Kind() == constant.Complex
+	}
+	if numeric(x) && numeric(y) {
+		return true
+	}
+	return x.Kind() == y.Kind()
+}
+
+// Returns the value of the integer variable v.
+func (thread *ThreadContext) intValue(v *Variable) (int64, error) {
+	c, err := thread.variableConstant(v)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := constant.Int64Val(c)
+	if c.Kind() != constant.Int || !ok {
+		return 0, fmt.Errorf("%s is not an integer", v.Name)
+	}
+	return n, nil
+}
+
+// Converts a variable of a basic type to a constant.
+func (thread *ThreadContext) variableConstant(v *Variable) (constant.Value, error) {
+	if v.ConstValue != nil {
+		return v.ConstValue, nil
+	}
+	if !v.addressable() {
+		return nil, fmt.Errorf("can not use %s of type %s in an expression", v.Name, v.typeString())
+	}
+
+	switch t := resolveTypedef(v.dwarfType).(type) {
+	case *dwarf.IntType:
+		val, err := thread.readVariable(v, 0, uintptr(t.ByteSize))
+		if err != nil {
+			return nil, err
+		}
+		return constant.MakeInt64(intFromBytes(val)), nil
+	case *dwarf.UintType:
+		val, err := thread.readVariable(v, 0, uintptr(t.ByteSize))
+		if err != nil {
+			return nil, err
+		}
+		return constant.MakeUint64(uintFromBytes(val)), nil
+	case *dwarf.FloatType:
+		val, err := thread.readVariable(v, 0, uintptr(t.ByteSize))
+		if err != nil {
+			return nil, err
+		}
+		n, err := floatFromBytes(val)
+		if err != nil {
+			return nil, err
+		}
+		if math.IsNaN(n) || math.IsInf(n, 0) {
+			return nil, fmt.Errorf("can not use %s in an expression, its value %v is not finite", v.Name, n)
+		}
+		return constant.MakeFloat64(n), nil
+	case *dwarf.ComplexType:
+		val, err := thread.readVariable(v, 0, uintptr(t.ByteSize))
+		if err != nil {
+			return nil, err
+		}
+		return complexFromBytes(val)
+	case *dwarf.BoolType:
+		val, err := thread.readVariable(v, 0, 1)
+		if err != nil {
+			return nil, err
+		}
+		return constant.MakeBool(val[0] != 0), nil
+	case *dwarf.PtrType:
+		n, err := thread.readPointer(v)
+		if err != nil {
+			return nil, err
+		}
+		return constant.MakeUint64(n), nil
+	case *dwarf.StructType:
+		if t.StructName == "string" {
+			s, err := thread.readString(v)
+			if err != nil {
+				return nil, err
+			}
+			return constant.MakeString(s), nil
+		}
+	}
+	return nil, fmt.Errorf("can not use %s of type %s in an expression", v.Name, v.typeString())
+}
+
+// Loads the value of a variable produced by the evaluator, as far as cfg
+// allows, and formats it.
+func (thread *ThreadContext) loadValue(v *Variable, cfg LoadConfig) error {
+	v.Type = v.typeString()
+	v.Kind = kindOf(v.dwarfType)
+	v.RealType = resolveTypedef(v.dwarfType)
+
+	switch {
+	case v.ConstValue != nil:
+		if v.dwarfType == nil {
+			switch v.ConstValue.Kind() {
+			case constant.Bool:
+				v.Kind = reflect.Bool
+			case constant.String:
+				v.Kind = reflect.String
+			case constant.Int:
+				v.Kind = reflect.Int
+			case constant.Float:
+				v.Kind = reflect.Float64
+			case constant.Complex:
+				v.Kind = reflect.Complex128
+			}
+		}
+		ptr, ok := v.RealType.(*dwarf.PtrType)
+		if !ok || v.isNil() || v.Kind == reflect.UnsafePointer {
+			break
+		}
+		if !cfg.FollowPointers {
+			v.Unloaded = true
+			break
+		}
+		addr, _ := constant.Uint64Val(v.ConstValue)
+		child := newVariable("", uintptr(addr), ptr.Type)
+		v.Children = []*Variable{child}
+		if err := thread.loadVariable(child, 0, cfg); err != nil {
+			return err
+		}
+	case !v.addressable():
+		_, _, _, elem, err := thread.sliceHeader(v)
+		if err != nil {
+			return err
+		}
+		if err := thread.loadElements(v, &Variable{Addr: v.base}, typeSize(elem), elem, cfg); err != nil {
+			return err
+		}
+	default:
+		if err := thread.loadVariable(v, 0, cfg); err != nil {
+			return err
+		}
+	}
+
+	v.format(true, formatOptions{hexDumpBytes: cfg.HexDumpBytes, verb: cfg.Format})
+	return nil
+}
+
+// Returns whether the value of v can be loaded, either from the memory of
+// the target or from the bytes it was assembled from.
+func (v *Variable) addressable() bool {
+	return v.ConstValue == nil && (v.Addr != 0 || v.data != nil)
+}
+
+// Returns whether v lives in the memory of the target.
+func (v *Variable) inMemory() bool {
+	return v.ConstValue == nil && v.Addr != 0
+}
+
+func (v *Variable) typeString() string {
+	if v.dwarfType != nil {
+		return v.dwarfType.String()
+	}
+	if v.ConstValue == nil {
+		return "unknown"
+	}
+	switch v.ConstValue.Kind() {
+	case constant.Bool:
+		return "untyped bool"
+	case constant.String:
+		return "untyped string"
+	case constant.Int:
+		return "untyped int"
+	case constant.Float:
+		return "untyped float"
+	case constant.Complex:
+		return "untyped complex"
+	}
+	return "unknown"
+}
+
+// Returns the source representation of expr.
+func exprToString(expr ast.Expr) string {
+	var buf bytes.Buffer
+	printer.Fprint(&buf, token.NewFileSet(), expr)
+	return strings.TrimSpace(buf.String())
+}
@@ -0,0 +1,348 @@
+package proctl
+
+import (
+	"debug/dwarf"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"go/constant"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Options changing how values are formatted.
+type formatOptions struct {
+	// Print []byte values as a hex dump instead of a quoted string.
+	hexDumpBytes bool
+	// Format verb of LoadConfig.Format.
+	verb byte
+}
+
+// Formats v the way the command line client prints it, storing the
+// result in the Value of v and of all its children. The names of struct
+// types are printed only if printStructName is set, which is the case
+// for top level values and values reached through them by fields and
+// pointers, but not for elements.
+func (v *Variable) format(printStructName bool, opts formatOptions) string {
+	v.Value = v.formatValue(printStructName, opts)
+	return v.Value
+}
+
+func (v *Variable) formatValue(printStructName bool, opts formatOptions) string {
+	if opts.verb != 0 {
+		if s, ok := v.formatVerb(opts.verb); ok {
+			return s
+		}
+	}
+
+	switch v.Kind {
+	case reflect.UnsafePointer:
+		if v.isNil() {
+			return "unsafe.Pointer nil"
+		}
+		addr, _ := constant.Uint64Val(v.ConstValue)
+		return fmt.Sprintf("(unsafe.Pointer) %#x", addr)
+
+	case reflect.Ptr:
+		if v.isNil() {
+			return fmt.Sprintf("%s nil", v.RealType)
+		}
+		if v.Unloaded {
+			addr, _ := constant.Uint64Val(v.ConstValue)
+			return fmt.Sprintf("(%s) %#x", v.RealType, addr)
+		}
+		return "*" + v.Children[0].format(printStructName, opts)
+
+	case reflect.Map:
+		var name string
+		if hmap, ok := mapHeader(v.RealType); ok {
+			if l, err := mapLayoutFor(hmap); err == nil {
+				name = fmt.Sprintf("map[%s]%s", l.keyType, l.valueType)
+			}
+		}
+		if v.isNil() {
+			return fmt.Sprintf("%s nil", name)
+		}
+		vals := make([]string, 0, len(v.Children)/2+1)
+		for i := 0; i+1 < len(v.Children); i += 2 {
+			vals = append(vals, fmt.Sprintf("%s: %s", v.Children[i].format(false, opts), v.Children[i+1].format(false, opts)))
+		}
+		if more := v.Len - int64(len(v.Children)/2); more > 0 {
+			vals = append(vals, fmt.Sprintf("...+%d more", more))
+		}
+		return fmt.Sprintf("%s [%s]", name, strings.Join(vals, ", "))
+
+	case reflect.Chan:
+		var name string
+		if hchan, ok := chanHeader(v.RealType); ok {
+			if elem, err := chanElemType(hchan); err == nil {
+				name = fmt.Sprintf("chan %s", elem)
+			}
+		}
+		if v.isNil() {
+			return fmt.Sprintf("%s nil", name)
+		}
+		buf, closed, recvq, sendq := v.Children[0], v.Children[1], v.Children[2], v.Children[3]
+		return fmt.Sprintf("%s len: %d, cap: %d, closed: %s, [%s], recvq: [%s], sendq: [%s]", name, v.Len, v.Cap,
+			closed.format(false, opts), formatElements(buf, opts), formatElements(recvq, opts), formatElements(sendq, opts))
+
+	case reflect.String:
+		s := constant.StringVal(v.ConstValue)
+		if more := v.Len - int64(len(s)); more > 0 {
+			return fmt.Sprintf("%s...+%d more", s, more)
+		}
+		return s
+
+	case reflect.Slice:
+		var elem dwarf.Type
+		if st, ok := v.RealType.(*dwarf.StructType); ok {
+			elem = sliceElemType(st)
+		}
+		if isByte(elem) && (opts.verb == 0 || opts.verb == 'r') {
+			// A hex dump starts on a line of its own.
+			sep := " "
+			if opts.hexDumpBytes {
+				sep = "\n"
+			}
+			return fmt.Sprintf("[]%s len: %d, cap: %d,%s%s", elem, v.Len, v.Cap, sep, formatBytes(v, opts))
+		}
+		return fmt.Sprintf("[]%s len: %d, cap: %d, [%s]", elem, v.Len, v.Cap, formatElements(v, opts))
+
+	case reflect.Array:
+		if v.Len == 0 {
+			return fmt.Sprintf("%s []", v.RealType)
+		}
+		return fmt.Sprintf("%s [%s]", v.RealType, formatElements(v, opts))
+
+	case reflect.Interface:
+		if len(v.Children) == 0 {
+			return fmt.Sprintf("%s nil", v.Type)
+		}
+		child := v.Children[0]
+		return fmt.Sprintf("%s(%s) %s", v.Type, child.Type, child.format(false, opts))
+
+	case reflect.Struct:
+		var name string
+		if st, ok := v.RealType.(*dwarf.StructType); ok {
+			name = st.StructName
+		}
+		if v.Unloaded {
+			if printStructName {
+				return fmt.Sprintf("%s {...}", name)
+			}
+			return "{...}"
+		}
+		fields := make([]string, 0, len(v.Children))
+		for _, field := range v.Children {
+			fields = append(fields, fmt.Sprintf("%s: %s", field.Name, field.format(printStructName, opts)))
+		}
+		if printStructName {
+			return fmt.Sprintf("%s {%s}", name, strings.Join(fields, ", "))
+		}
+		return fmt.Sprintf("{%s}", strings.Join(fields, ", "))
+
+	case reflect.Float32, reflect.Float64:
+		f := v.floatValue()
+		if v.Kind == reflect.Float32 {
+			return strconv.FormatFloat(f, 'f', -1, 32)
+		}
+		return strconv.FormatFloat(f, 'f', -1, 64)
+
+	case reflect.Complex64, reflect.Complex128:
+		re, _ := constant.Float64Val(constant.ToFloat(constant.Real(v.ConstValue)))
+		im, _ := constant.Float64Val(constant.ToFloat(constant.Imag(v.ConstValue)))
+		if v.Kind == reflect.Complex64 {
+			return fmt.Sprint(complex(float32(re), float32(im)))
+		}
+		return fmt.Sprint(complex(re, im))
+
+	case reflect.Uintptr:
+		n, _ := constant.Uint64Val(v.ConstValue)
+		return fmt.Sprintf("%#x", n)
+
+	case reflect.Int32:
+		// rune is an alias of int32 that DWARF does not tell apart, so
+		// printable values are shown as characters too.
+		n, _ := constant.Int64Val(v.ConstValue)
+		if n >= ' ' && n <= unicode.MaxRune && unicode.IsPrint(rune(n)) {
+			return fmt.Sprintf("%d %s", n, strconv.QuoteRune(rune(n)))
+		}
+		return strconv.FormatInt(n, 10)
+
+	case reflect.Func:
+		if v.ConstValue == nil {
+			return "nil"
+		}
+		s := constant.StringVal(v.ConstValue)
+		if v.File != "" {
+			s += fmt.Sprintf(" %s:%d", v.File, v.Line)
+		}
+		if v.Unloaded {
+			return s + " {...}"
+		}
+		if len(v.Children) == 0 {
+			return s
+		}
+		captured := make([]string, 0, len(v.Children))
+		for _, cv := range v.Children {
+			captured = append(captured, fmt.Sprintf("%s: %s", cv.Name, cv.format(printStructName, opts)))
+		}
+		return fmt.Sprintf("%s {%s}", s, strings.Join(captured, ", "))
+	}
+
+	switch v.RealType.(type) {
+	case *dwarf.VoidType:
+		return "(void)"
+	case *dwarf.UnspecifiedType:
+		return "(unknown)"
+	}
+	if v.ConstValue != nil {
+		return v.ConstValue.ExactString()
+	}
+	return ""
+}
+
+// Formats the elements of v, noting how many were not loaded.
+func formatElements(v *Variable, opts formatOptions) string {
+	vals := make([]string, 0, len(v.Children)+1)
+	for _, elem := range v.Children {
+		vals = append(vals, elem.format(false, opts))
+	}
+	if more := v.Len - int64(len(v.Children)); more > 0 {
+		vals = append(vals, fmt.Sprintf("...+%d more", more))
+	}
+	return strings.Join(vals, ",")
+}
+
+// Formats the elements of the []byte v as a quoted string or, if
+// requested, as a hex dump.
+func formatBytes(v *Variable, opts formatOptions) string {
+	b := make([]byte, len(v.Children))
+	for i, elem := range v.Children {
+		elem.format(false, opts)
+		n, _ := constant.Uint64Val(elem.ConstValue)
+		b[i] = byte(n)
+	}
+	more := v.Len - int64(len(b))
+	if opts.verb == 'r' {
+		return formatRaw(b, more)
+	}
+	if opts.hexDumpBytes {
+		s := strings.TrimSuffix(hex.Dump(b), "\n")
+		if more > 0 {
+			s += fmt.Sprintf("\n...+%d more", more)
+		}
+		return s
+	}
+	s := strconv.Quote(string(b))
+	if more > 0 {
+		s += fmt.Sprintf("...+%d more", more)
+	}
+	return s
+}
+
+// Formats the scalar v with a format verb, returning false if the verb
+// does not apply to values of its kind.
+func (v *Variable) formatVerb(verb byte) (string, bool) {
+	if v.ConstValue == nil {
+		return "", false
+	}
+	if verb == 'r' {
+		return v.formatRawValue()
+	}
+
+	switch v.Kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+	default:
+		return "", false
+	}
+	var n interface{}
+	if i, exact := constant.Int64Val(v.ConstValue); exact {
+		n = i
+	} else {
+		n, _ = constant.Uint64Val(v.ConstValue)
+	}
+	switch verb {
+	case 'x':
+		return fmt.Sprintf("%#x", n), true
+	case 'o':
+		return fmt.Sprintf("%#o", n), true
+	case 'b':
+		return fmt.Sprintf("%#b", n), true
+	case 'c':
+		return fmt.Sprintf("%q", n), true
+	}
+	return "", false
+}
+
+// Formats the bytes the scalar v is stored as in memory.
+func (v *Variable) formatRawValue() (string, bool) {
+	var b []byte
+	switch v.Kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.RealType == nil {
+			return "", false
+		}
+		b = make([]byte, 8)
+		if i, exact := constant.Int64Val(v.ConstValue); exact {
+			binary.LittleEndian.PutUint64(b, uint64(i))
+		} else {
+			n, _ := constant.Uint64Val(v.ConstValue)
+			binary.LittleEndian.PutUint64(b, n)
+		}
+		b = b[:v.RealType.Size()]
+	case reflect.Float32:
+		b = make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, math.Float32bits(float32(v.floatValue())))
+	case reflect.Float64:
+		f := v.floatValue()
+		b = make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, math.Float64bits(f))
+	case reflect.Bool:
+		b = []byte{0}
+		if constant.BoolVal(v.ConstValue) {
+			b[0] = 1
+		}
+	case reflect.String:
+		b = []byte(constant.StringVal(v.ConstValue))
+		return formatRaw(b, v.Len-int64(len(b))), true
+	default:
+		return "", false
+	}
+	return formatRaw(b, 0), true
+}
+
+// Formats b as a list of hex bytes, noting how many were not loaded.
+func formatRaw(b []byte, more int64) string {
+	if more > 0 {
+		return fmt.Sprintf("[% x ...+%d more]", b, more)
+	}
+	return fmt.Sprintf("[% x]", b)
+}
+
+// Returns whether t is byte, or uint8.
+func isByte(t dwarf.Type) bool {
+	u, ok := resolveTypedef(t).(*dwarf.UintType)
+	return ok && u.ByteSize == 1
+}
+
+// Returns whether the pointer, map or channel v is nil.
+func (v *Variable) isNil() bool {
+	addr, _ := constant.Uint64Val(v.ConstValue)
+	return addr == 0
+}
+
+// Returns the element type of the slice type t.
+func sliceElemType(t *dwarf.StructType) dwarf.Type {
+	for _, f := range t.Field {
+		if ptr, ok := f.Type.(*dwarf.PtrType); ok && f.Name == "array" {
+			return ptr.Type
+		}
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"debug/gosym"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -33,6 +34,7 @@ type DebuggedProcess struct {
 	Threads             map[int]*ThreadContext
 	CurrentBreakpoint   *BreakPoint
 	CurrentThread       *ThreadContext
+	TraceOutput         io.Writer // Tracepoint messages are logged here, os.Stdout by default.
 	dwarf               *dwarf.Data
 	goSymTable          *gosym.Table
 	frameEntries        frame.FrameDescriptionEntries
@@ -40,6 +42,12 @@ type DebuggedProcess struct {
 	os                  *OSProcessDetails
 	ast                 *source.Searcher
 	breakpointIDCounter int
+	fieldOffsets        map[string]int64
+	types               map[string]dwarf.Type
+	packageVars         map[string]dwarf.Offset
+	packages            map[string]bool
+	gStructOffset       uint64
+	minTopHash          uint8
 	running             bool
 	halt                bool
 	exited              bool
@@ -215,7 +223,7 @@ func (dbp *DebuggedProcess) BreakByLocation(loc string) (*BreakPoint, error) {
 	return dbp.Break(addr)
 }
 
-// Clears a breakpoint in the current thread.
+// Clears a breakpoint. Hardware breakpoints are cleared on every thread.
 func (dbp *DebuggedProcess) Clear(addr uint64) (*BreakPoint, error) {
 	tid := dbp.CurrentThread.Id
 	// Check for hardware breakpoint
@@ -225,7 +233,7 @@ func (dbp *DebuggedProcess) Clear(addr uint64) (*BreakPoint, error) {
 		}
 		if bp.Addr == addr {
 			dbp.HWBreakPoints[i] = nil
-			if err := clearHardwareBreakpoint(i, tid); err != nil {
+			if err := dbp.clearHardwareBreakpointAllThreads(i); err != nil {
 				return nil, err
 			}
 			return bp, nil
@@ -268,14 +276,18 @@ func (dbp *DebuggedProcess) next() error {
 		return err
 	}
 	defer dbp.clearTempBreakpoints()
+	// Set every breakpoint before resuming any thread, hardware
+	// breakpoints can only be programmed into stopped threads.
 	for _, th := range dbp.Threads {
-		if th.blocked() { // Continue threads that aren't running go code.
-			if err := th.Continue(); err != nil {
-				return err
-			}
+		if th.blocked() {
 			continue
 		}
-		if err := th.Next(); err != nil {
+		if err := th.setNextBreakpoints(); err != nil {
+			return err
+		}
+	}
+	for _, th := range dbp.Threads {
+		if err := th.Continue(); err != nil {
 			return err
 		}
 	}
@@ -308,6 +320,109 @@ func (dbp *DebuggedProcess) next() error {
 	return dbp.Halt()
 }
 
+// Step out of the current function, stopping in the caller once the
+// function returns. Returns the values the function returned, when they
+// are described by the debug information.
+func (dbp *DebuggedProcess) StepOut() ([]*Variable, error) {
+	var retvals []*Variable
+	err := dbp.run(func() error {
+		var err error
+		retvals, err = dbp.stepout()
+		return err
+	})
+	return retvals, err
+}
+
+func (dbp *DebuggedProcess) stepout() ([]*Variable, error) {
+	thread := dbp.CurrentThread
+	curg, err := thread.curG()
+	if err != nil {
+		return nil, err
+	}
+	regs, err := thread.Registers()
+	if err != nil {
+		return nil, err
+	}
+	pc := regs.PC()
+	if bp, ok := dbp.BreakPoints[pc-1]; ok {
+		pc = bp.Addr
+	}
+
+	// The CFA of the current frame is the value the stack pointer will
+	// have once we return to the caller. Recursive calls returning to the
+	// same address will do so with a lower stack pointer.
+	fde, err := dbp.frameEntries.FDEForPC(pc)
+	if err != nil {
+		return nil, err
+	}
+	cfa := uint64(int64(regs.SP()) + fde.EstablishFrame(pc).CFAOffset())
+	retaddr := thread.ReturnAddressFromOffset(fde.ReturnAddressOffset(pc))
+
+	retentries, err := thread.returnValueEntries(pc)
+	if err != nil {
+		return nil, err
+	}
+
+	defer dbp.clearTempBreakpoints()
+	bp, err := dbp.Break(retaddr)
+	if err != nil {
+		if _, ok := err.(BreakPointExistsError); !ok {
+			return nil, err
+		}
+	} else {
+		bp.Temp = true
+	}
+
+	for _, th := range dbp.Threads {
+		if err := th.Continue(); err != nil {
+			return nil, err
+		}
+	}
+
+	for {
+		thread, err = trapWait(dbp, -1)
+		if err != nil {
+			return nil, err
+		}
+		if dbp.CurrentThread != thread {
+			dbp.SwitchThread(thread.Id)
+		}
+		// Stopped for a reason other than our return address,
+		// give control back to the user.
+		bp, err := thread.stoppedAtBreakpoint()
+		if err != nil {
+			return nil, err
+		}
+		if bp == nil || bp.Addr != retaddr {
+			return nil, dbp.Halt()
+		}
+		tg, err := thread.curG()
+		if err != nil {
+			return nil, err
+		}
+		regs, err := thread.Registers()
+		if err != nil {
+			return nil, err
+		}
+		if tg.Id == curg.Id && regs.SP() >= cfa {
+			if err = thread.clearTempBreakpoint(retaddr); err != nil {
+				return nil, err
+			}
+			break
+		}
+		// Another goroutine, or a deeper recursive call, returned
+		// to the same address. Keep going.
+		if err := thread.Continue(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := dbp.Halt(); err != nil {
+		return nil, err
+	}
+	return thread.returnValues(retentries, int64(cfa))
+}
+
 // Resume process.
 func (dbp *DebuggedProcess) Continue() error {
 	for _, thread := range dbp.Threads {
@@ -320,10 +435,50 @@ func (dbp *DebuggedProcess) Continue() error {
 }
 
 func (dbp *DebuggedProcess) resume() error {
-	thread, err := trapWait(dbp, -1)
-	if err != nil {
-		return err
+	for {
+		thread, err := trapWait(dbp, -1)
+		if err != nil {
+			return err
+		}
+		if bp := dbp.CurrentBreakpoint; bp != nil && !bp.Temp {
+			g, err := thread.curG()
+			if _, ok := err.(NoGError); ok {
+				g, err = nil, nil
+			}
+			stop := true
+			if err == nil {
+				stop, err = bp.checkCondition(thread, g)
+				if err != nil {
+					err = fmt.Errorf("could not evaluate condition of breakpoint %d: %s", bp.ID, err)
+				}
+			}
+			if err == nil && stop && bp.Tracepoint {
+				fmt.Fprintln(dbp.TraceOutput, bp.traceMessage(thread, g))
+				stop = false
+			}
+			if err == nil && !stop {
+				// Condition is false or this is a tracepoint,
+				// let this thread keep running.
+				dbp.CurrentBreakpoint = nil
+				if err := dbp.continueThread(thread); err != nil {
+					return err
+				}
+				continue
+			}
+			if dbp.CurrentThread != thread {
+				dbp.SwitchThread(thread.Id)
+			}
+			if herr := dbp.Halt(); herr != nil {
+				return herr
+			}
+			return err
+		}
+		return dbp.handleUnknownTrap(thread)
 	}
+}
+
+// Handles a trap that did not come from one of our breakpoints.
+func (dbp *DebuggedProcess) handleUnknownTrap(thread *ThreadContext) error {
 	if dbp.CurrentThread != thread {
 		dbp.SwitchThread(thread.Id)
 	}
@@ -331,11 +486,6 @@ func (dbp *DebuggedProcess) resume() error {
 	if err != nil {
 		return err
 	}
-	if dbp.CurrentBreakpoint != nil {
-		if !dbp.CurrentBreakpoint.Temp {
-			return dbp.Halt()
-		}
-	}
 	// Check to see if we hit a runtime.breakpoint
 	fn := dbp.goSymTable.PCToFunc(pc)
 	if fn != nil && fn.Name == "runtime.breakpoint" {
@@ -372,6 +522,7 @@ func (dbp *DebuggedProcess) Step() (err error) {
 // Change from current thread to the thread specified by `tid`.
 func (dbp *DebuggedProcess) SwitchThread(tid int) error {
 	if th, ok := dbp.Threads[tid]; ok {
+		dbp.CurrentThread.g = nil
 		dbp.CurrentThread = th
 		fmt.Printf("thread context changed from %d to %d\n", dbp.CurrentThread.Id, tid)
 		return nil
@@ -379,6 +530,58 @@ func (dbp *DebuggedProcess) SwitchThread(tid int) error {
 	return fmt.Errorf("thread %d does not exist", tid)
 }
 
+// Selects the goroutine with the given ID for inspection. If it is
+// running, the thread running it becomes the current thread. Otherwise
+// its stack and variables are read starting from the registers saved
+// when it was parked, until the process is resumed.
+func (dbp *DebuggedProcess) SwitchGoroutine(gid int) error {
+	gs, err := dbp.GoroutinesInfo()
+	if err != nil {
+		return err
+	}
+	var g *G
+	for _, cur := range gs {
+		if cur.Id == gid {
+			g = cur
+			break
+		}
+	}
+	if g == nil {
+		return fmt.Errorf("goroutine %d does not exist", gid)
+	}
+
+	allm, err := dbp.CurrentThread.AllM()
+	if err != nil {
+		return err
+	}
+	for _, m := range allm {
+		if m.curg != uintptr(g.addr) {
+			continue
+		}
+		if th, ok := dbp.Threads[m.procid]; ok {
+			if err := dbp.SwitchThread(th.Id); err != nil {
+				return err
+			}
+			th.frame = 0
+			return nil
+		}
+	}
+
+	dbp.CurrentThread.g = g
+	dbp.CurrentThread.frame = 0
+	return nil
+}
+
+// Returns the goroutine selected for inspection: either a parked
+// goroutine selected with SwitchGoroutine or the goroutine running
+// on the current thread.
+func (dbp *DebuggedProcess) SelectedGoroutine() (*G, error) {
+	if g := dbp.CurrentThread.g; g != nil {
+		return g, nil
+	}
+	return dbp.CurrentThread.curG()
+}
+
 func (dbp *DebuggedProcess) GoroutinesInfo() ([]*G, error) {
 	var (
 		allg   []*G
@@ -398,7 +601,7 @@ func (dbp *DebuggedProcess) GoroutinesInfo() ([]*G, error) {
 	allgptr := binary.LittleEndian.Uint64(faddr)
 
 	for i := uint64(0); i < allglen; i++ {
-		g, err := parseG(dbp, allgptr+(i*uint64(ptrsize)), reader)
+		g, err := parseG(dbp.CurrentThread, allgptr+(i*uint64(ptrsize)))
 		if err != nil {
 			return nil, err
 		}
@@ -413,14 +616,34 @@ func (dbp *DebuggedProcess) Registers() (Registers, error) {
 	return dbp.CurrentThread.Registers()
 }
 
+// Reads size bytes of the memory of the target process at addr. Software
+// breakpoints are hidden: their bytes read as the original data.
+func (dbp *DebuggedProcess) ReadMemory(addr uintptr, size int) ([]byte, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid size %d", size)
+	}
+	data, err := dbp.CurrentThread.readMemory(addr, uintptr(size))
+	if err != nil {
+		return nil, err
+	}
+	for bpaddr, bp := range dbp.BreakPoints {
+		for i, b := range bp.OriginalData {
+			if a := uintptr(bpaddr) + uintptr(i); a >= addr && a < addr+uintptr(size) {
+				data[a-addr] = b
+			}
+		}
+	}
+	return data, nil
+}
+
 // Returns the PC of the current thread.
 func (dbp *DebuggedProcess) CurrentPC() (uint64, error) {
 	return dbp.CurrentThread.CurrentPC()
 }
 
 // Returns the value of the named symbol.
-func (dbp *DebuggedProcess) EvalSymbol(name string) (*Variable, error) {
-	return dbp.CurrentThread.EvalSymbol(name)
+func (dbp *DebuggedProcess) EvalSymbol(name string, cfg LoadConfig) (*Variable, error) {
+	return dbp.CurrentThread.EvalSymbol(name, cfg)
 }
 
 func (dbp *DebuggedProcess) CallFn(name string, fn func(*ThreadContext) error) error {
@@ -440,6 +663,11 @@ func (dbp *DebuggedProcess) Funcs() []gosym.Func {
 	return dbp.goSymTable.Funcs
 }
 
+// Returns the function with the given name, or nil if there is none.
+func (dbp *DebuggedProcess) LookupFunc(name string) *gosym.Func {
+	return dbp.goSymTable.LookupFunc(name)
+}
+
 func (dbp *DebuggedProcess) PCToLine(pc uint64) (string, int, *gosym.Func) {
 	return dbp.goSymTable.PCToLine(pc)
 }
@@ -457,12 +685,28 @@ func (dbp *DebuggedProcess) FindBreakpoint(pc uint64) (*BreakPoint, bool) {
 	return nil, false
 }
 
+// Finds the breakpoint with the given ID.
+func (dbp *DebuggedProcess) FindBreakpointByID(id int) (*BreakPoint, bool) {
+	for _, bp := range dbp.HWBreakPoints {
+		if bp != nil && bp.ID == id {
+			return bp, true
+		}
+	}
+	for _, bp := range dbp.BreakPoints {
+		if bp.ID == id {
+			return bp, true
+		}
+	}
+	return nil, false
+}
+
 // Returns a new DebuggedProcess struct.
 func newDebugProcess(pid int, attach bool) (*DebuggedProcess, error) {
 	dbp := DebuggedProcess{
 		Pid:         pid,
 		Threads:     make(map[int]*ThreadContext),
 		BreakPoints: make(map[uint64]*BreakPoint),
+		TraceOutput: os.Stdout,
 		os:          new(OSProcessDetails),
 		ast:         source.New(),
 	}
@@ -522,9 +766,21 @@ func (dbp *DebuggedProcess) handleBreakpointOnThread(id int) (*ThreadContext, er
 	if err != nil {
 		return nil, err
 	}
+	// Check whether a debug register fired because of a watchpoint.
+	reg, err := hardwareBreakpointHit(thread.Id)
+	if err != nil {
+		return nil, err
+	}
+	if reg >= 0 {
+		if bp := dbp.HWBreakPoints[reg]; bp != nil && bp.WatchType != 0 {
+			bp.watchHit(thread)
+			dbp.CurrentBreakpoint = bp
+			return thread, nil
+		}
+	}
 	// Check for hardware breakpoint
 	for _, bp := range dbp.HWBreakPoints {
-		if bp != nil && bp.Addr == pc {
+		if bp != nil && bp.WatchType == 0 && bp.Addr == pc {
 			dbp.CurrentBreakpoint = bp
 			return thread, nil
 		}
@@ -544,6 +800,10 @@ func (dbp *DebuggedProcess) run(fn func() error) error {
 	dbp.running = true
 	dbp.halt = false
 	dbp.CurrentBreakpoint = nil
+	for _, th := range dbp.Threads {
+		th.frame = 0
+		th.g = nil
+	}
 	defer func() { dbp.running = false }()
 	if err := fn(); err != nil {
 		if _, ok := err.(ManualStopError); !ok {
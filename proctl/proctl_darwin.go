@@ -32,6 +32,13 @@ func (dbp *DebuggedProcess) Halt() (err error) {
 	return nil
 }
 
+// Continues thread, which stopped at a breakpoint.
+func (dbp *DebuggedProcess) continueThread(thread *ThreadContext) error {
+	// TODO(darwin) halt the other threads while stepping over a
+	// software breakpoint.
+	return thread.Continue()
+}
+
 func (dbp *DebuggedProcess) updateThreadList() error {
 	var (
 		err   error
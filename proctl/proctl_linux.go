@@ -76,6 +76,10 @@ func (dbp *DebuggedProcess) addThread(tid int, attach bool) (*ThreadContext, err
 		}
 	}
 
+	if err := dbp.setHardwareBreakpointsOnThread(tid); err != nil {
+		return nil, fmt.Errorf("could not set hardware breakpoints on new thread %d %s", tid, err)
+	}
+
 	dbp.Threads[tid] = &ThreadContext{
 		Id:      tid,
 		Process: dbp,
@@ -126,10 +130,37 @@ func (dbp *DebuggedProcess) findExecutable() (*elf.File, error) {
 		return nil, err
 	}
 	dbp.dwarf = data
+	dbp.gStructOffset = gStructOffset(elffile)
 
 	return elffile, nil
 }
 
+// Returns the offset of the current G pointer from the thread pointer.
+// The TLS segment ends at the thread pointer, and runtime.tlsg is the
+// offset of the G pointer from the start of that segment. Binaries
+// without it keep the G pointer right below the thread pointer.
+func gStructOffset(exe *elf.File) uint64 {
+	var tls *elf.Prog
+	for _, prog := range exe.Progs {
+		if prog.Type == elf.PT_TLS {
+			tls = prog
+			break
+		}
+	}
+	syms, err := exe.Symbols()
+	if tls == nil || err != nil {
+		return ^uint64(ptrsize) + 1
+	}
+	for _, sym := range syms {
+		if sym.Name == "runtime.tlsg" {
+			// Round the segment size up to a pointer boundary.
+			memsz := (tls.Memsz + uint64(ptrsize) - 1) &^ uint64(ptrsize-1)
+			return ^memsz + 1 + sym.Value
+		}
+	}
+	return ^uint64(ptrsize) + 1
+}
+
 func (dbp *DebuggedProcess) parseDebugFrame(exe *elf.File, wg *sync.WaitGroup) {
 	defer wg.Done()
 
@@ -197,6 +228,14 @@ func (dbp *DebuggedProcess) parseDebugLineInfo(exe *elf.File, wg *sync.WaitGroup
 	}
 }
 
+// Removes a thread that has exited from the list of traced threads.
+func (dbp *DebuggedProcess) forgetThread(tid int) {
+	delete(dbp.Threads, tid)
+	if dbp.CurrentThread != nil && dbp.CurrentThread.Id == tid {
+		dbp.CurrentThread = dbp.Threads[dbp.Pid]
+	}
+}
+
 func stopped(pid int) bool {
 	f, err := os.Open(fmt.Sprintf("/proc/%d/stat", pid))
 	if err != nil {
@@ -216,6 +255,66 @@ func stopped(pid int) bool {
 	return false
 }
 
+// Starts tracing the thread the thread tid just cloned, leaving it
+// stopped.
+func (dbp *DebuggedProcess) haltClonedThread(tid int) error {
+	cloned, err := sys.PtraceGetEventMsg(tid)
+	if err != nil {
+		return fmt.Errorf("could not get event message: %s", err)
+	}
+	if _, err := dbp.addThread(int(cloned), false); err != nil {
+		return err
+	}
+	// New threads start with a SIGSTOP.
+	if _, _, err := wait(int(cloned), 0); err != nil {
+		return fmt.Errorf("wait err %s %d", err, cloned)
+	}
+	return nil
+}
+
+// Continues thread, which stopped at a breakpoint. Stepping over a
+// software breakpoint puts the original instruction back for a moment,
+// the other threads are halted meanwhile so that they can not run past
+// the breakpoint unnoticed.
+func (dbp *DebuggedProcess) continueThread(thread *ThreadContext) error {
+	pc, err := thread.CurrentPC()
+	if err != nil {
+		return err
+	}
+	if _, ok := dbp.BreakPoints[pc-1]; !ok {
+		return thread.Continue()
+	}
+
+	// Threads that already stopped have a trap waiting to be handled.
+	waiting := make(map[int]bool)
+	for _, th := range dbp.Threads {
+		if th == thread {
+			continue
+		}
+		if stopped(th.Id) {
+			waiting[th.Id] = true
+			continue
+		}
+		if err := th.Halt(); err != nil {
+			return err
+		}
+	}
+	if err := thread.Step(); err != nil {
+		return fmt.Errorf("could not step %s", err)
+	}
+	// Threads cloned while halting the others are resumed as well.
+	for _, th := range dbp.Threads {
+		if waiting[th.Id] {
+			continue
+		}
+		// The process may exit as soon as one thread runs again.
+		if err := th.resume(); err != nil && err != sys.ESRCH {
+			return err
+		}
+	}
+	return nil
+}
+
 func trapWait(dbp *DebuggedProcess, pid int) (*ThreadContext, error) {
 	for {
 		wpid, status, err := wait(pid, 0)
@@ -233,6 +332,11 @@ func trapWait(dbp *DebuggedProcess, pid int) (*ThreadContext, error) {
 			dbp.exited = true
 			return nil, ProcessExitedError{Pid: wpid, Status: status.ExitStatus()}
 		}
+		if status.Exited() || status.Signaled() {
+			// A thread other than the main one has exited, stop tracking it.
+			dbp.forgetThread(wpid)
+			continue
+		}
 		if status.StopSignal() == sys.SIGTRAP && status.TrapCause() == sys.PTRACE_EVENT_CLONE {
 			// A traced thread has cloned a new thread, grab the pid and
 			// add it to our list of traced threads.
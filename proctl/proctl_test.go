@@ -3,11 +3,15 @@ package proctl
 import (
 	"bytes"
 	"encoding/binary"
+	"go/parser"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
+
+	"golang.org/x/arch/x86/x86asm"
 )
 
 func withTestProcess(name string, t *testing.T, fn func(p *DebuggedProcess)) {
@@ -412,3 +416,500 @@ func TestFunctionCall(t *testing.T) {
 		}
 	})
 }
+
+func TestStacktrace(t *testing.T) {
+	withTestProcess("../_fixtures/testnextprog", t, func(p *DebuggedProcess) {
+		pc, err := p.FindLocation("main.helloworld")
+		assertNoError(err, t, "FindLocation()")
+		_, err = p.Break(pc)
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		frames, err := p.Stacktrace(10)
+		assertNoError(err, t, "Stacktrace()")
+
+		expected := []string{"main.helloworld", "main.testnext", "main.main"}
+		if len(frames) < len(expected) {
+			t.Fatalf("expected at least %d frames, got %d", len(expected), len(frames))
+		}
+		for i, name := range expected {
+			if frames[i].Fn == nil || frames[i].Fn.Name != name {
+				t.Fatalf("frame %d: expected %s got %#v", i, name, frames[i].Fn)
+			}
+		}
+		if frames[1].Line != 34 {
+			t.Fatalf("expected caller frame at line 34, got %s:%d", frames[1].File, frames[1].Line)
+		}
+	})
+}
+
+func TestStacktraceFramePointers(t *testing.T) {
+	withTestProcess("../_fixtures/testnextprog", t, func(p *DebuggedProcess) {
+		pc, err := p.FindLocation("main.helloworld")
+		assertNoError(err, t, "FindLocation()")
+		_, err = p.Break(pc)
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		frames, err := p.Stacktrace(2)
+		assertNoError(err, t, "Stacktrace()")
+		// At its entry main.helloworld did not set up its frame yet,
+		// BP is the frame pointer of its caller.
+		if len(frames) != 2 || frames[1].BP != frames[1].CFA-2*uint64(ptrsize) || frames[0].BP != frames[1].BP {
+			t.Fatalf("unexpected frame pointers %#v", frames)
+		}
+	})
+}
+
+func TestStepOut(t *testing.T) {
+	withTestProcess("../_fixtures/teststepout", t, func(p *DebuggedProcess) {
+		pc, err := p.FindLocation("main.sum")
+		assertNoError(err, t, "FindLocation()")
+		_, err = p.Break(pc)
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+		_, err = p.Clear(pc)
+		assertNoError(err, t, "Clear()")
+
+		retvals, err := p.StepOut()
+		assertNoError(err, t, "StepOut()")
+
+		fn := p.goSymTable.PCToFunc(currentPC(p, t))
+		if fn == nil || fn.Name != "main.main" {
+			t.Fatalf("expected to return to main.main, got %#v", fn)
+		}
+		if len(retvals) != 1 || retvals[0].Value != "3" {
+			t.Fatalf("unexpected return values %#v", retvals)
+		}
+	})
+}
+
+func TestStepOutRecursive(t *testing.T) {
+	withTestProcess("../_fixtures/teststepout", t, func(p *DebuggedProcess) {
+		pc, err := p.FindLocation("main.recurse")
+		assertNoError(err, t, "FindLocation()")
+		_, err = p.Break(pc)
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+		_, err = p.Clear(pc)
+		assertNoError(err, t, "Clear()")
+
+		// The deeper recursive calls return to the same address
+		// and must not stop the step out.
+		retvals, err := p.StepOut()
+		assertNoError(err, t, "StepOut()")
+
+		fn := p.goSymTable.PCToFunc(currentPC(p, t))
+		if fn == nil || fn.Name != "main.main" {
+			t.Fatalf("expected to return to main.main, got %#v", fn)
+		}
+		if len(retvals) != 1 || retvals[0].Value != "6" {
+			t.Fatalf("unexpected return values %#v", retvals)
+		}
+	})
+}
+
+func TestConditionalBreakpoint(t *testing.T) {
+	fp, err := filepath.Abs("../_fixtures/testnextprog.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withTestProcess("../_fixtures/testnextprog", t, func(p *DebuggedProcess) {
+		pc, _, _ := p.goSymTable.LineToPC(fp, 24)
+		bp, err := p.Break(pc)
+		assertNoError(err, t, "Break()")
+		bp.Cond, err = p.ParseExpr("i == 2 && j > 0")
+		assertNoError(err, t, "ParseExpr()")
+
+		assertNoError(p.Continue(), t, "Continue()")
+
+		v, err := p.EvalSymbol("i", DefaultLoadConfig)
+		assertNoError(err, t, "EvalSymbol()")
+		if v.Value != "2" {
+			t.Fatalf("stopped with i = %s, expected 2", v.Value)
+		}
+	})
+}
+
+func TestConditionalBreakpointEvalError(t *testing.T) {
+	fp, err := filepath.Abs("../_fixtures/testnextprog.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withTestProcess("../_fixtures/testnextprog", t, func(p *DebuggedProcess) {
+		pc, _, _ := p.goSymTable.LineToPC(fp, 24)
+		bp, err := p.Break(pc)
+		assertNoError(err, t, "Break()")
+		bp.Cond, err = parser.ParseExpr("nonexistent == 1")
+		assertNoError(err, t, "ParseExpr()")
+
+		if err := p.Continue(); err == nil {
+			t.Fatal("expected error evaluating condition")
+		}
+		_, l := currentLineNumber(p, t)
+		if l != 24 {
+			t.Fatalf("expected to stop at line 24, stopped at %d", l)
+		}
+	})
+}
+
+func TestBreakpointHitCount(t *testing.T) {
+	fp, err := filepath.Abs("../_fixtures/testnextprog.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withTestProcess("../_fixtures/testnextprog", t, func(p *DebuggedProcess) {
+		pc, _, _ := p.goSymTable.LineToPC(fp, 24)
+		bp, err := p.Break(pc)
+		assertNoError(err, t, "Break()")
+		bp.HitCond, err = ParseHitCondition("%", "2")
+		assertNoError(err, t, "ParseHitCondition()")
+
+		assertNoError(p.Continue(), t, "Continue()")
+
+		v, err := p.EvalSymbol("i", DefaultLoadConfig)
+		assertNoError(err, t, "EvalSymbol()")
+		if v.Value != "1" {
+			t.Fatalf("stopped with i = %s, expected 1", v.Value)
+		}
+		if bp.TotalHitCount != 2 {
+			t.Fatalf("expected 2 hits, got %d", bp.TotalHitCount)
+		}
+		var n uint64
+		for _, c := range bp.HitCount {
+			n += c
+		}
+		if n != 2 || len(bp.HitCount) != 1 {
+			t.Fatalf("unexpected goroutine hit counts %v", bp.HitCount)
+		}
+	})
+}
+
+func TestTracepoint(t *testing.T) {
+	fp, err := filepath.Abs("../_fixtures/testnextprog.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withTestProcess("../_fixtures/testnextprog", t, func(p *DebuggedProcess) {
+		pc, _, _ := p.goSymTable.LineToPC(fp, 24)
+		tp, err := p.Break(pc)
+		assertNoError(err, t, "Break()")
+		tp.Tracepoint = true
+		tp.Variables = []string{"i", "j"}
+		var out bytes.Buffer
+		p.TraceOutput = &out
+
+		pc, _, _ = p.goSymTable.LineToPC(fp, 34)
+		_, err = p.Break(pc)
+		assertNoError(err, t, "Break()")
+
+		assertNoError(p.Continue(), t, "Continue()")
+
+		_, l := currentLineNumber(p, t)
+		if l != 34 {
+			t.Fatalf("tracepoint stopped execution, line %d", l)
+		}
+		if tp.TotalHitCount != 3 {
+			t.Fatalf("expected 3 hits, got %d", tp.TotalHitCount)
+		}
+		if n := strings.Count(out.String(), "> goroutine("); n != 3 {
+			t.Fatalf("expected 3 trace messages, got %d: %q", n, out.String())
+		}
+	})
+}
+
+func TestTracepointAllThreads(t *testing.T) {
+	withTestProcess("../_fixtures/testhwthreads", t, func(p *DebuggedProcess) {
+		// Use up the debug registers to get a software breakpoint.
+		hw := p.HWBreakPoints
+		for i := range p.HWBreakPoints {
+			p.HWBreakPoints[i] = &BreakPoint{}
+		}
+		tp, err := p.BreakByLocation("main.work")
+		p.HWBreakPoints = hw
+		assertNoError(err, t, "BreakByLocation()")
+		if tp.OriginalData == nil {
+			t.Fatal("expected a software breakpoint")
+		}
+		tp.Tracepoint = true
+		var out bytes.Buffer
+		p.TraceOutput = &out
+
+		// No thread may run past the breakpoint while another one
+		// steps over it.
+		err = p.Continue()
+		if _, ok := err.(ProcessExitedError); !ok {
+			t.Fatalf("expected the process to exit, got %v", err)
+		}
+		if tp.TotalHitCount != 400 {
+			t.Fatalf("expected 400 hits, got %d", tp.TotalHitCount)
+		}
+	})
+}
+
+func TestWatchpoint(t *testing.T) {
+	fp, err := filepath.Abs("../_fixtures/testwatch.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withTestProcess("../_fixtures/testwatch", t, func(p *DebuggedProcess) {
+		pc, _, _ := p.goSymTable.LineToPC(fp, 11)
+		bp, err := p.Break(pc)
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+		_, err = p.Clear(bp.Addr)
+		assertNoError(err, t, "Clear()")
+
+		wp, err := p.Watch("c.count", WatchWrite)
+		assertNoError(err, t, "Watch()")
+
+		for _, want := range []string{"2", "4"} {
+			assertNoError(p.Continue(), t, "Continue()")
+			if p.CurrentBreakpoint != wp {
+				t.Fatalf("stopped at %v instead of the watchpoint", p.CurrentBreakpoint)
+			}
+			if wp.NewValue != want {
+				t.Fatalf("new value %s, expected %s (old value %s)", wp.NewValue, want, wp.OldValue)
+			}
+			if _, l, _ := p.goSymTable.PCToLine(wp.TriggerPC); l != 12 {
+				t.Fatalf("watchpoint triggered by %#v %q at line %d", wp.TriggerPC, wp.TriggerInst, l)
+			}
+		}
+		if wp.OldValue != "2" {
+			t.Fatalf("old value %s, expected 2", wp.OldValue)
+		}
+	})
+}
+
+func TestReadWatchpoint(t *testing.T) {
+	fp, err := filepath.Abs("../_fixtures/testwatch.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withTestProcess("../_fixtures/testwatch", t, func(p *DebuggedProcess) {
+		pc, _, _ := p.goSymTable.LineToPC(fp, 11)
+		bp, err := p.Break(pc)
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+		_, err = p.Clear(bp.Addr)
+		assertNoError(err, t, "Clear()")
+
+		wp, err := p.Watch("c.count", WatchRead)
+		assertNoError(err, t, "Watch()")
+
+		// c.count += 2 reads c.count, even when a single instruction
+		// adds to it in place and changes the value.
+		for i := 0; i < 3; i++ {
+			assertNoError(p.Continue(), t, "Continue()")
+			if p.CurrentBreakpoint != wp {
+				t.Fatalf("stopped at %v instead of the watchpoint", p.CurrentBreakpoint)
+			}
+			if _, l, _ := p.goSymTable.PCToLine(wp.TriggerPC); l != 12 || !wp.triggerRead {
+				t.Fatalf("watchpoint triggered by %#v %q at line %d", wp.TriggerPC, wp.TriggerInst, l)
+			}
+		}
+	})
+}
+
+func TestReadsMemory(t *testing.T) {
+	testcases := []struct {
+		code  []byte
+		reads bool
+	}{
+		{[]byte{0x48, 0x89, 0x03}, false},             // MOVQ AX, 0(BX)
+		{[]byte{0x48, 0x8b, 0x03}, true},              // MOVQ 0(BX), AX
+		{[]byte{0x48, 0x01, 0x03}, true},              // ADDQ AX, 0(BX)
+		{[]byte{0x48, 0x83, 0x3b, 0x00}, true},        // CMPQ $0x0, 0(BX)
+		{[]byte{0x0f, 0x94, 0x03}, false},             // SETE 0(BX)
+		{[]byte{0x50}, false},                         // PUSHQ AX
+		{[]byte{0xff, 0x33}, true},                    // PUSHQ 0(BX)
+		{[]byte{0x58}, true},                          // POPQ AX
+		{[]byte{0x8f, 0x03}, false},                   // POPQ 0(BX)
+		{[]byte{0xe8, 0x00, 0x00, 0x00, 0x00}, false}, // CALL
+		{[]byte{0xf2, 0x0f, 0x11, 0x03}, false},       // MOVSD_XMM X0, 0(BX)
+	}
+	for _, tc := range testcases {
+		inst, err := x86asm.Decode(tc.code, 64)
+		assertNoError(err, t, "Decode()")
+		if readsMemory(inst) != tc.reads {
+			t.Errorf("% x: expected %v", tc.code, tc.reads)
+		}
+	}
+}
+
+func TestHardwareBreakpointAllThreads(t *testing.T) {
+	withTestProcess("../_fixtures/testhwthreads", t, func(p *DebuggedProcess) {
+		bp, err := p.BreakByLocation("main.work")
+		assertNoError(err, t, "BreakByLocation()")
+		if bp.OriginalData != nil {
+			t.Fatal("expected a hardware breakpoint")
+		}
+
+		// The goroutines run on threads created after the breakpoint
+		// was set, each of them must trap. A goroutine may call work
+		// all 100 times before the next one gets to run.
+		threads := make(map[int]bool)
+		for i := 0; i < 400 && len(threads) < 2; i++ {
+			assertNoError(p.Continue(), t, "Continue()")
+			if p.CurrentBreakpoint != bp {
+				t.Fatalf("stopped at %v instead of %v", p.CurrentBreakpoint, bp)
+			}
+			threads[p.CurrentThread.Id] = true
+		}
+		if len(threads) < 2 {
+			t.Fatalf("breakpoint was only hit on threads %v", threads)
+		}
+	})
+}
+
+func TestWatchpointNewThreads(t *testing.T) {
+	withTestProcess("../_fixtures/testwatchthreads", t, func(p *DebuggedProcess) {
+		bp, err := p.BreakByLocation("main.main")
+		assertNoError(err, t, "BreakByLocation()")
+		assertNoError(p.Continue(), t, "Continue()")
+		_, err = p.Clear(bp.Addr)
+		assertNoError(err, t, "Clear()")
+
+		// The goroutines writing to total run on threads created
+		// after the watchpoint was set.
+		wp, err := p.Watch("total", WatchWrite)
+		assertNoError(err, t, "Watch()")
+		threads := len(p.Threads)
+		for i := 0; i < 4; i++ {
+			assertNoError(p.Continue(), t, "Continue()")
+			if p.CurrentBreakpoint != wp {
+				t.Fatalf("stopped at %v instead of the watchpoint", p.CurrentBreakpoint)
+			}
+		}
+		if wp.NewValue != "10" {
+			t.Fatalf("new value %s, expected 10", wp.NewValue)
+		}
+		if len(p.Threads) <= threads {
+			t.Fatalf("no thread was created after setting the watchpoint")
+		}
+	})
+}
+
+func TestCurG(t *testing.T) {
+	withTestProcess("../_fixtures/testhwthreads", t, func(p *DebuggedProcess) {
+		_, err := p.BreakByLocation("main.work")
+		assertNoError(err, t, "BreakByLocation()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		g, err := p.CurrentThread.curG()
+		assertNoError(err, t, "curG()")
+		if g.Func == nil {
+			t.Fatalf("goroutine %d has no function", g.Id)
+		}
+		// Every other thread either runs a goroutine or is
+		// reported as running none.
+		for _, th := range p.Threads {
+			if _, err := th.curG(); err != nil {
+				if _, ok := err.(NoGError); !ok {
+					t.Fatalf("curG() of thread %d: %s", th.Id, err)
+				}
+			}
+		}
+	})
+}
+
+func TestGoroutineBreakpoint(t *testing.T) {
+	withTestProcess("../_fixtures/testhwthreads", t, func(p *DebuggedProcess) {
+		bp, err := p.BreakByLocation("main.work")
+		assertNoError(err, t, "BreakByLocation()")
+		assertNoError(p.Continue(), t, "Continue()")
+		g, err := p.CurrentThread.curG()
+		assertNoError(err, t, "curG()")
+		bp.GoroutineID = g.Id
+
+		for i := 0; i < 8; i++ {
+			assertNoError(p.Continue(), t, "Continue()")
+			cur, err := p.CurrentThread.curG()
+			assertNoError(err, t, "curG()")
+			if cur.Id != g.Id {
+				t.Fatalf("stopped in goroutine %d, expected %d", cur.Id, g.Id)
+			}
+		}
+		if bp.TotalHitCount != 9 || bp.HitCount[g.Id] != 9 {
+			t.Fatalf("unexpected hit counts %d %v", bp.TotalHitCount, bp.HitCount)
+		}
+	})
+}
+
+func TestSwitchGoroutine(t *testing.T) {
+	withTestProcess("../_fixtures/testhwthreads", t, func(p *DebuggedProcess) {
+		_, err := p.BreakByLocation("main.work")
+		assertNoError(err, t, "BreakByLocation()")
+		assertNoError(p.Continue(), t, "Continue()")
+		worker, err := p.CurrentThread.curG()
+		assertNoError(err, t, "curG()")
+		tid := p.CurrentThread.Id
+
+		// The main goroutine is parked in wg.Wait.
+		assertNoError(p.SwitchGoroutine(1), t, "SwitchGoroutine()")
+		g, err := p.SelectedGoroutine()
+		assertNoError(err, t, "SelectedGoroutine()")
+		if g.Id != 1 {
+			t.Fatalf("selected goroutine %d, expected 1", g.Id)
+		}
+		frames, err := p.Stacktrace(50)
+		assertNoError(err, t, "Stacktrace()")
+		found := false
+		for _, frame := range frames {
+			if frame.Fn != nil && frame.Fn.Name == "main.main" {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("main.main not in the stack of goroutine 1: %v", frames)
+		}
+
+		// Selecting a running goroutine selects its thread.
+		assertNoError(p.SwitchGoroutine(worker.Id), t, "SwitchGoroutine()")
+		if p.CurrentThread.Id != tid {
+			t.Fatalf("current thread %d, expected %d", p.CurrentThread.Id, tid)
+		}
+		frames, err = p.Stacktrace(1)
+		assertNoError(err, t, "Stacktrace()")
+		if frames[0].Fn == nil || frames[0].Fn.Name != "main.work" {
+			t.Fatalf("goroutine %d is not in main.work: %v", worker.Id, frames[0])
+		}
+	})
+}
+
+func TestReadMemory(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		fn := p.goSymTable.LookupFunc("main.helloworld")
+		if fn == nil {
+			t.Fatal("No fn exists")
+		}
+
+		code, err := p.ReadMemory(uintptr(fn.Entry), 16)
+		assertNoError(err, t, "ReadMemory()")
+
+		_, err = p.Break(fn.Entry)
+		assertNoError(err, t, "Break()")
+
+		// The breakpoint is hidden from the caller.
+		data, err := p.ReadMemory(uintptr(fn.Entry), 16)
+		assertNoError(err, t, "ReadMemory()")
+		if !bytes.Equal(code, data) {
+			t.Fatalf("Expected % x got % x", code, data)
+		}
+
+		insts, err := p.Disassemble(fn.Entry, 3)
+		assertNoError(err, t, "Disassemble()")
+		if len(insts) != 3 || insts[0].Addr != fn.Entry || insts[1].Addr != fn.Entry+uint64(len(insts[0].Bytes)) {
+			t.Fatalf("Unexpected instructions %v", insts)
+		}
+		if !bytes.Equal(insts[0].Bytes, code[:len(insts[0].Bytes)]) {
+			t.Fatalf("Expected % x got % x", code[:len(insts[0].Bytes)], insts[0].Bytes)
+		}
+	})
+}
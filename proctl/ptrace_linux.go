@@ -31,3 +31,22 @@ func PtracePeekUser(tid int, off uintptr) (uintptr, error) {
 	}
 	return val, nil
 }
+
+// The floating point and SSE registers of a thread, struct
+// user_fpregs_struct of sys/user.h, laid out as by FXSAVE.
+type PtraceFpRegs struct {
+	Cwd, Swd, Ftw, Fop uint16
+	Rip, Rdp           uint64
+	Mxcsr, MxcrMask    uint32
+	StSpace            [32]uint32
+	XmmSpace           [64]uint32
+	Padding            [24]uint32
+}
+
+func PtraceGetFpRegs(tid int, regs *PtraceFpRegs) error {
+	_, _, err := syscall.Syscall6(syscall.SYS_PTRACE, syscall.PTRACE_GETFPREGS, uintptr(tid), 0, uintptr(unsafe.Pointer(regs)), 0, 0)
+	if err != syscall.Errno(0) {
+		return err
+	}
+	return nil
+}
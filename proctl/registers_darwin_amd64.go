@@ -4,8 +4,18 @@ package proctl
 import "C"
 import "fmt"
 
+// DWARF numbers of the frame, stack and instruction pointers.
+const (
+	dwarfRegBP = 6
+	dwarfRegSP = 7
+	dwarfRegPC = 16
+)
+
 type Regs struct {
 	pc, sp uint64
+	// General purpose registers and the PC, in the order of their
+	// DWARF numbers.
+	dwarfRegs []uint64
 }
 
 func (r *Regs) PC() uint64 {
@@ -16,6 +26,13 @@ func (r *Regs) SP() uint64 {
 	return r.sp
 }
 
+func (r *Regs) DwarfRegister(n uint64) (uint64, error) {
+	if n >= uint64(len(r.dwarfRegs)) {
+		return 0, fmt.Errorf("register %d is not available, only the general purpose registers are", n)
+	}
+	return r.dwarfRegs[n], nil
+}
+
 func (r *Regs) SetPC(thread *ThreadContext, pc uint64) error {
 	kret := C.set_pc(thread.os.thread_act, C.uint64_t(pc))
 	if kret != C.KERN_SUCCESS {
@@ -31,5 +48,12 @@ func registers(thread *ThreadContext) (Registers, error) {
 		return nil, fmt.Errorf("could not get registers")
 	}
 	regs := &Regs{pc: uint64(state.__rip), sp: uint64(state.__rsp)}
+	regs.dwarfRegs = []uint64{
+		uint64(state.__rax), uint64(state.__rdx), uint64(state.__rcx), uint64(state.__rbx),
+		uint64(state.__rsi), uint64(state.__rdi), uint64(state.__rbp), uint64(state.__rsp),
+		uint64(state.__r8), uint64(state.__r9), uint64(state.__r10), uint64(state.__r11),
+		uint64(state.__r12), uint64(state.__r13), uint64(state.__r14), uint64(state.__r15),
+		uint64(state.__rip),
+	}
 	return regs, nil
 }
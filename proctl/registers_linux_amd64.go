@@ -1,9 +1,26 @@
 package proctl
 
-import sys "golang.org/x/sys/unix"
+import (
+	"fmt"
+
+	sys "golang.org/x/sys/unix"
+)
+
+// DWARF numbers of the frame, stack and instruction pointers, and of
+// the first and last SSE registers.
+const (
+	dwarfRegBP    = 6
+	dwarfRegSP    = 7
+	dwarfRegPC    = 16
+	dwarfRegXMM0  = 17
+	dwarfRegXMM15 = 32
+)
 
 type Regs struct {
+	tid  int
 	regs *sys.PtraceRegs
+	// The SSE registers, read when first needed.
+	fpregs *PtraceFpRegs
 }
 
 func (r *Regs) PC() uint64 {
@@ -14,6 +31,33 @@ func (r *Regs) SP() uint64 {
 	return r.regs.Rsp
 }
 
+// Returns the register with DWARF number n. Of the SSE registers XMM0 to
+// XMM15 only the low 8 bytes are returned, which hold the float values
+// the compiler keeps in them.
+func (r *Regs) DwarfRegister(n uint64) (uint64, error) {
+	if n >= dwarfRegXMM0 && n <= dwarfRegXMM15 {
+		if r.fpregs == nil {
+			var fpregs PtraceFpRegs
+			if err := PtraceGetFpRegs(r.tid, &fpregs); err != nil {
+				return 0, err
+			}
+			r.fpregs = &fpregs
+		}
+		i := (n - dwarfRegXMM0) * 4
+		return uint64(r.fpregs.XmmSpace[i]) | uint64(r.fpregs.XmmSpace[i+1])<<32, nil
+	}
+	// Registers in the order of their DWARF numbers.
+	regs := []uint64{
+		r.regs.Rax, r.regs.Rdx, r.regs.Rcx, r.regs.Rbx, r.regs.Rsi, r.regs.Rdi, r.regs.Rbp, r.regs.Rsp,
+		r.regs.R8, r.regs.R9, r.regs.R10, r.regs.R11, r.regs.R12, r.regs.R13, r.regs.R14, r.regs.R15,
+		r.regs.Rip,
+	}
+	if n >= uint64(len(regs)) {
+		return 0, fmt.Errorf("register %d is not available, only the general purpose and XMM0-XMM15 registers are", n)
+	}
+	return regs[n], nil
+}
+
 func (r *Regs) SetPC(thread *ThreadContext, pc uint64) error {
 	r.regs.SetPC(pc)
 	return sys.PtraceSetRegs(thread.Id, r.regs)
@@ -25,5 +69,5 @@ func registers(thread *ThreadContext) (Registers, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Regs{&regs}, nil
+	return &Regs{tid: thread.Id, regs: &regs}, nil
 }
@@ -0,0 +1,154 @@
+package proctl
+
+import (
+	"debug/gosym"
+	"encoding/binary"
+	"fmt"
+)
+
+// Stackframe represents a single frame of a goroutine's call stack.
+// PC is the address execution will resume at in this frame, SP and BP
+// are the values of the stack and frame pointers at that address and
+// CFA is the canonical frame address as described by the frame's FDE.
+// BP is 0 when it is not known.
+type Stackframe struct {
+	PC   uint64
+	SP   uint64
+	BP   uint64
+	CFA  uint64
+	File string
+	Line int
+	Fn   *gosym.Func
+
+	// PC used to resolve the function and line of this frame. For
+	// every frame but the innermost it points into the call instruction.
+	scopePC uint64
+}
+
+// Returns the call stack of the current thread, starting at the
+// innermost frame and walking at most `depth` frames.
+func (dbp *DebuggedProcess) Stacktrace(depth int) ([]Stackframe, error) {
+	return dbp.CurrentThread.Stacktrace(depth)
+}
+
+// Returns the call stack of this thread, starting at the innermost
+// frame and walking at most `depth` frames.
+func (thread *ThreadContext) Stacktrace(depth int) ([]Stackframe, error) {
+	// A parked goroutine selected through this thread is unwound
+	// from the registers the scheduler saved for it.
+	if thread.g != nil {
+		return thread.stacktrace(thread.g.PC, thread.g.SP, thread.g.BP, depth)
+	}
+
+	regs, err := thread.Registers()
+	if err != nil {
+		return nil, err
+	}
+
+	pc := regs.PC()
+	// Correct the PC if we are sitting just past a software breakpoint.
+	if bp, ok := thread.Process.BreakPoints[pc-1]; ok {
+		pc = bp.Addr
+	}
+	bp, err := regs.DwarfRegister(dwarfRegBP)
+	if err != nil {
+		return nil, err
+	}
+	return thread.stacktrace(pc, regs.SP(), bp, depth)
+}
+
+// Unwinds the stack beginning at the given pc, sp and bp. Each frame is
+// found by applying the CFA rules of the FDE covering the frame's PC: the
+// CFA is the caller's SP, and the return address is stored at a known
+// offset from the callee's SP. Functions with a frame save the frame
+// pointer of their caller right below the return address, and point BP
+// there.
+func (thread *ThreadContext) stacktrace(pc, sp, bp uint64, depth int) ([]Stackframe, error) {
+	frames := make([]Stackframe, 0, depth)
+
+	for i := 0; i < depth; i++ {
+		fde, err := thread.Process.frameEntries.FDEForPC(pc)
+		if err != nil {
+			// We have walked off the end of the known frames.
+			if i == 0 {
+				return nil, err
+			}
+			break
+		}
+
+		fctx := fde.EstablishFrame(pc)
+		cfa := uint64(int64(sp) + fctx.CFAOffset())
+
+		// Return addresses point to the instruction following the
+		// call, so look up the source position of the call itself.
+		lookup := pc
+		if i > 0 {
+			lookup--
+		}
+		f, l, fn := thread.Process.goSymTable.PCToLine(lookup)
+		frames = append(frames, Stackframe{PC: pc, SP: sp, BP: bp, CFA: cfa, File: f, Line: l, Fn: fn, scopePC: lookup})
+
+		if fn == nil || fn.Name == "runtime.goexit" {
+			break
+		}
+
+		retaddr := uintptr(int64(sp) + fde.ReturnAddressOffset(pc))
+		data, err := thread.readMemory(retaddr, ptrsize)
+		if err != nil {
+			return nil, err
+		}
+
+		pc = binary.LittleEndian.Uint64(data)
+		sp = cfa
+		// BP points at the saved frame pointer of the caller once a
+		// function set up its frame. Elsewhere, such as in a prologue
+		// or a function without a frame, BP still is the caller's.
+		if bp != 0 && bp == cfa-2*uint64(ptrsize) {
+			data, err := thread.readMemory(uintptr(bp), ptrsize)
+			if err != nil {
+				return nil, err
+			}
+			bp = binary.LittleEndian.Uint64(data)
+		}
+		if pc == 0 {
+			break
+		}
+	}
+
+	return frames, nil
+}
+
+// Selects frame `n` of this thread's call stack, 0 being the innermost
+// frame. Variable evaluation is relative to the selected frame until
+// the process is resumed.
+func (thread *ThreadContext) SelectFrame(n int) error {
+	if n < 0 {
+		return fmt.Errorf("invalid frame %d", n)
+	}
+	frames, err := thread.Stacktrace(n + 1)
+	if err != nil {
+		return err
+	}
+	if len(frames) <= n {
+		return fmt.Errorf("frame %d does not exist", n)
+	}
+	thread.frame = n
+	return nil
+}
+
+// Returns the index of the currently selected frame.
+func (thread *ThreadContext) SelectedFrame() int {
+	return thread.frame
+}
+
+// Returns the selected frame of this thread.
+func (thread *ThreadContext) currentFrame() (*Stackframe, error) {
+	frames, err := thread.Stacktrace(thread.frame + 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(frames) <= thread.frame {
+		return nil, fmt.Errorf("frame %d does not exist", thread.frame)
+	}
+	return &frames[thread.frame], nil
+}
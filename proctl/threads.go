@@ -17,6 +17,12 @@ type ThreadContext struct {
 	Process *DebuggedProcess
 	Status  *sys.WaitStatus
 	os      *OSSpecificDetails
+	frame   int
+	// Parked goroutine selected for inspection through this thread.
+	g *G
+	// Hardware breakpoints changed while this thread was running, its
+	// debug registers must be programmed again before it resumes.
+	hwBreakPointsStale bool
 }
 
 // An interface for a generic register type. The
@@ -27,6 +33,8 @@ type Registers interface {
 	PC() uint64
 	SP() uint64
 	SetPC(*ThreadContext, uint64) error
+	// Returns the value of the register with the given DWARF number.
+	DwarfRegister(n uint64) (uint64, error)
 }
 
 // Obtains register values from the debugged process.
@@ -52,6 +60,9 @@ func (thread *ThreadContext) CurrentPC() (uint64, error) {
 // we step over any breakpoints. It will restore the instruction,
 // step, and then restore the breakpoint and continue.
 func (thread *ThreadContext) Continue() error {
+	if err := thread.syncHardwareBreakpoints(); err != nil {
+		return err
+	}
 	pc, err := thread.CurrentPC()
 	if err != nil {
 		return err
@@ -69,9 +80,25 @@ func (thread *ThreadContext) Continue() error {
 	return thread.resume()
 }
 
+// Programs the hardware breakpoints that changed while this thread
+// was running.
+func (thread *ThreadContext) syncHardwareBreakpoints() error {
+	if !thread.hwBreakPointsStale {
+		return nil
+	}
+	if err := thread.Process.setHardwareBreakpointsOnThread(thread.Id); err != nil {
+		return fmt.Errorf("could not set hardware breakpoints on thread %d %s", thread.Id, err)
+	}
+	thread.hwBreakPointsStale = false
+	return nil
+}
+
 // Single steps this thread a single instruction, ensuring that
 // we correctly handle the likely case that we are at a breakpoint.
 func (thread *ThreadContext) Step() (err error) {
+	if err = thread.syncHardwareBreakpoints(); err != nil {
+		return err
+	}
 	pc, err := thread.CurrentPC()
 	if err != nil {
 		return err
@@ -79,10 +106,11 @@ func (thread *ThreadContext) Step() (err error) {
 
 	bp, ok := thread.Process.BreakPoints[pc-1]
 	if ok {
-		// Clear the breakpoint so that we can continue execution.
-		_, err = thread.Process.Clear(bp.Addr)
-		if err != nil {
-			return err
+		// Restore the original instruction so that we can continue execution.
+		// The breakpoint itself stays in the breakpoint table so that its
+		// identity and state survive stepping over it.
+		if _, err = writeMemory(thread, uintptr(bp.Addr), bp.OriginalData); err != nil {
+			return fmt.Errorf("could not restore instruction %s", err)
 		}
 
 		// Reset program counter to our restored instruction.
@@ -93,9 +121,9 @@ func (thread *ThreadContext) Step() (err error) {
 
 		// Restore breakpoint now that we have passed it.
 		defer func() {
-			var nbp *BreakPoint
-			nbp, err = thread.Process.Break(bp.Addr)
-			nbp.Temp = bp.Temp
+			if _, werr := writeMemory(thread, uintptr(bp.Addr), []byte{0xCC}); werr != nil && err == nil {
+				err = fmt.Errorf("could not restore breakpoint %s", werr)
+			}
 		}()
 	}
 
@@ -145,7 +173,15 @@ func (thread *ThreadContext) CallFn(name string, fn func(*ThreadContext) error)
 // This functionality is implemented by finding all possible next lines
 // and setting a breakpoint at them. Once we've set a breakpoint at each
 // potential line, we continue the thread.
-func (thread *ThreadContext) Next() (err error) {
+func (thread *ThreadContext) Next() error {
+	if err := thread.setNextBreakpoints(); err != nil {
+		return err
+	}
+	return thread.Continue()
+}
+
+// Sets temporary breakpoints at every line Next could stop at.
+func (thread *ThreadContext) setNextBreakpoints() (err error) {
 	curpc, err := thread.CurrentPC()
 	if err != nil {
 		return err
@@ -193,7 +229,7 @@ func (thread *ThreadContext) Next() (err error) {
 			bp.Temp = true
 		}
 	}
-	return thread.Continue()
+	return nil
 }
 
 func (thread *ThreadContext) SetPC(pc uint64) error {
@@ -236,16 +272,29 @@ func (thread *ThreadContext) clearTempBreakpoint(pc uint64) error {
 	return nil
 }
 
-func (thread *ThreadContext) curG() (*G, error) {
-	var g *G
-	err := thread.CallFn("runtime.getg", func(t *ThreadContext) error {
-		regs, err := t.Registers()
-		if err != nil {
-			return err
+// Returns the execution breakpoint this thread is stopped at, nil if
+// there is none. Hardware breakpoints stop at their address, software
+// breakpoints right after it.
+func (thread *ThreadContext) stoppedAtBreakpoint() (*BreakPoint, error) {
+	pc, err := thread.CurrentPC()
+	if err != nil {
+		return nil, err
+	}
+	for _, bp := range thread.Process.HWBreakPoints {
+		if bp != nil && bp.WatchType == 0 && bp.Addr == pc {
+			return bp, nil
 		}
-		reader := t.Process.dwarf.Reader()
-		g, err = parseG(t.Process, regs.SP()+uint64(ptrsize), reader)
-		return err
-	})
-	return g, err
+	}
+	return thread.Process.BreakPoints[pc-1], nil
+}
+
+// NoGError is returned by curG for threads that are not running a
+// goroutine, such as threads running the scheduler or a system call
+// on the g0 stack.
+type NoGError struct {
+	tid int
+}
+
+func (ng NoGError) Error() string {
+	return fmt.Sprintf("no G executing on thread %d", ng.tid)
 }
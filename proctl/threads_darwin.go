@@ -98,3 +98,18 @@ func (thread *ThreadContext) restoreRegisters() error {
 	}
 	return nil
 }
+
+// Returns the goroutine running on this thread, by calling runtime.getg
+// on it.
+func (thread *ThreadContext) curG() (*G, error) {
+	var g *G
+	err := thread.CallFn("runtime.getg", func(t *ThreadContext) error {
+		regs, err := t.Registers()
+		if err != nil {
+			return err
+		}
+		g, err = parseG(t, regs.SP()+uint64(ptrsize))
+		return err
+	})
+	return g, err
+}
@@ -1,6 +1,7 @@
 package proctl
 
 import (
+	"encoding/binary"
 	"fmt"
 
 	sys "golang.org/x/sys/unix"
@@ -17,14 +18,53 @@ func (t *ThreadContext) Halt() error {
 		return nil
 	}
 	err := sys.Tgkill(t.Process.Pid, t.Id, sys.SIGSTOP)
+	if err == sys.ESRCH {
+		// The thread exited in the meantime.
+		t.Process.forgetThread(t.Id)
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("Halt err %s %d", err, t.Id)
 	}
-	_, _, err = wait(t.Id, 0)
-	if err != nil {
-		return fmt.Errorf("wait err %s %d", err, t.Id)
+	for {
+		_, status, err := wait(t.Id, 0)
+		if err != nil {
+			return fmt.Errorf("wait err %s %d", err, t.Id)
+		}
+		if status.Exited() || status.Signaled() {
+			t.Process.forgetThread(t.Id)
+			return nil
+		}
+		if status.StopSignal() == sys.SIGSTOP {
+			return nil
+		}
+		// The thread stopped for another reason before the SIGSTOP
+		// was delivered, let it run into the SIGSTOP.
+		sig := 0
+		switch {
+		case status.StopSignal() != sys.SIGTRAP:
+			sig = int(status.StopSignal())
+		case status.TrapCause() == sys.PTRACE_EVENT_CLONE:
+			if err := t.Process.haltClonedThread(t.Id); err != nil {
+				return err
+			}
+		default:
+			// Rewind a thread that hit one of our breakpoints
+			// so that it hits it again once resumed.
+			pc, err := t.CurrentPC()
+			if err != nil {
+				return err
+			}
+			if _, ok := t.Process.BreakPoints[pc-1]; ok {
+				if err := t.SetPC(pc - 1); err != nil {
+					return err
+				}
+			}
+		}
+		if err := PtraceCont(t.Id, sig); err != nil {
+			return err
+		}
 	}
-	return nil
 }
 
 func (t *ThreadContext) resume() error {
@@ -71,3 +111,22 @@ func (thread *ThreadContext) saveRegisters() error {
 func (thread *ThreadContext) restoreRegisters() error {
 	return sys.PtraceSetRegs(thread.Id, &thread.os.registers)
 }
+
+// Returns the goroutine running on this thread. The runtime keeps a
+// pointer to it in the thread local storage, which ends at the base of
+// the fs segment.
+func (thread *ThreadContext) curG() (*G, error) {
+	var regs sys.PtraceRegs
+	if err := sys.PtraceGetRegs(thread.Id, &regs); err != nil {
+		return nil, fmt.Errorf("could not get registers: %s", err)
+	}
+	gaddr := regs.Fs_base + thread.Process.gStructOffset
+	gbytes, err := thread.readMemory(uintptr(gaddr), ptrsize)
+	if err != nil {
+		return nil, fmt.Errorf("could not read G pointer of thread %d %s", thread.Id, err)
+	}
+	if binary.LittleEndian.Uint64(gbytes) == 0 {
+		return nil, NoGError{tid: thread.Id}
+	}
+	return parseG(thread, gaddr)
+}
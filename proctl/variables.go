@@ -1,11 +1,15 @@
 package proctl
 
 import (
-	"bytes"
 	"debug/dwarf"
 	"debug/gosym"
 	"encoding/binary"
 	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"math"
+	"reflect"
 	"strconv"
 	"strings"
 	"unsafe"
@@ -14,17 +18,109 @@ import (
 	"github.com/derekparker/delve/dwarf/reader"
 )
 
-const (
-	maxVariableRecurse = 1
-	maxArrayValues     = 64
-)
+// LoadConfig limits how much of the value of a variable is read from
+// the target process.
+type LoadConfig struct {
+	// Depth of nested structs whose members are loaded.
+	MaxVariableRecurse int
+	// Number of elements loaded from arrays, slices, maps and channels.
+	MaxArrayValues int
+	// Number of bytes loaded from strings. Negative loads them whole,
+	// up to maxStringLoad bytes.
+	MaxStringLen int
+	// Whether the values pointers point to are loaded, instead of just
+	// the addresses they hold.
+	FollowPointers bool
+	// Whether []byte values are printed as a hex dump instead of a
+	// quoted string.
+	HexDumpBytes bool
+	// Format verb applied to the integers of a value, including those
+	// in its elements and fields: 'x' for hexadecimal, 'o' for octal,
+	// 'b' for binary and 'c' for characters. 'r' prints the raw bytes
+	// of integers, floats, booleans and strings instead. Zero uses the
+	// default formatting.
+	Format byte
+}
+
+// Limits used when variables are loaded on behalf of the debugger itself,
+// and the default limits of the command line client.
+var DefaultLoadConfig = LoadConfig{MaxVariableRecurse: 1, MaxArrayValues: 64, MaxStringLen: 64, FollowPointers: true}
 
+// Variable is a value read from the target process. Besides the value
+// formatted for display, it describes the value as a tree that can be
+// navigated and compared.
 type Variable struct {
-	Name  string
+	Name string
+	// The value formatted the way the command line client prints it.
 	Value string
 	Type  string
+
+	// Kind of the value, reflect.Invalid for values of unknown types.
+	Kind reflect.Kind
+	// Address of the value, zero for values computed by the expression
+	// evaluator and for values that are not stored in memory as a whole.
+	Addr uintptr
+	// Type of the value with typedefs resolved.
+	RealType dwarf.Type
+
+	// Length of strings, arrays, slices and maps, and the number of
+	// values buffered in channels.
+	Len int64
+	// Capacity of slices and channels.
+	Cap int64
+
+	// Value of booleans, numbers and strings, the address held by
+	// pointers, maps and channels, and the name of functions. Also the
+	// result of computations of the expression evaluator, before the
+	// value is loaded.
+	ConstValue constant.Value
+	// Set for floats holding an infinity or NaN, which ConstValue can
+	// not represent.
+	FloatSpecial FloatSpecial
+
+	// Fields of structs, elements of arrays and slices, the value
+	// pointers point to, the dynamic value of interfaces and keys
+	// followed by their values for maps. Channels have the children
+	// buf, holding the buffered values in the order they will be
+	// received, closed, and recvq and sendq, holding the IDs of the
+	// goroutines waiting on the channel. Func values have the variables
+	// captured by the closure they refer to.
+	//
+	// Strings, arrays, slices, maps and channels with fewer values
+	// loaded than their length were truncated by the LoadConfig.
+	Children []*Variable
+	// Set when the children of a struct, pointer or closure were not
+	// loaded because of the LoadConfig, and for strings requested whole
+	// that were longer than maxStringLoad.
+	Unloaded bool
+	// Set for local variables hidden by a variable of the same name
+	// declared in an inner lexical block.
+	Shadowed bool
+
+	// Source location of the function a func value refers to.
+	File string
+	Line int
+
+	dwarfType dwarf.Type
+
+	// Start of the elements of a slice produced by slicing an array or
+	// a slice.
+	base uintptr
+	// Bytes of values assembled from pieces, such as those held in
+	// registers, which have no address.
+	data []byte
 }
 
+// FloatSpecial tells apart the float values that are not finite.
+type FloatSpecial uint8
+
+const (
+	FloatIsNormal FloatSpecial = iota
+	FloatIsNaN
+	FloatIsPosInf
+	FloatIsNegInf
+)
+
 type M struct {
 	procid   int
 	spinning uint8
@@ -32,12 +128,21 @@ type M struct {
 	curg     uintptr
 }
 
+// G represents a goroutine. PC, SP and BP are the registers saved in
+// g.sched when the goroutine was last descheduled, they are stale for
+// a goroutine that is currently running. BP is 0 for runtimes that do
+// not save the frame pointer.
 type G struct {
 	Id   int
 	PC   uint64
+	SP   uint64
+	BP   uint64
 	File string
 	Line int
 	Func *gosym.Func
+
+	// Address of the runtime.g structure.
+	addr uint64
 }
 
 const ptrsize uintptr = unsafe.Sizeof(int(1))
@@ -209,44 +314,110 @@ func parseAllMPtr(dbp *DebuggedProcess, reader *dwarf.Reader) (uint64, error) {
 	return uint64(addr), nil
 }
 
-func parseG(dbp *DebuggedProcess, addr uint64, reader *dwarf.Reader) (*G, error) {
-	gaddrbytes, err := dbp.CurrentThread.readMemory(uintptr(addr), ptrsize)
+// Reads the goroutine that the pointer at addr points to, through thread.
+func parseG(thread *ThreadContext, addr uint64) (*G, error) {
+	dbp := thread.Process
+	gaddrbytes, err := thread.readMemory(uintptr(addr), ptrsize)
 	if err != nil {
 		return nil, fmt.Errorf("error derefing *G %s", err)
 	}
-	initialInstructions := append([]byte{op.DW_OP_addr}, gaddrbytes...)
+	gaddr := binary.LittleEndian.Uint64(gaddrbytes)
 
-	reader.Seek(0)
-	goidaddr, err := offsetFor(dbp, "goid", reader, initialInstructions)
+	goidoff, err := dbp.fieldOffset("runtime.g", "goid")
 	if err != nil {
 		return nil, err
 	}
-	reader.Seek(0)
-	schedaddr, err := offsetFor(dbp, "sched", reader, initialInstructions)
+	schedoff, err := dbp.fieldOffset("runtime.g", "sched")
 	if err != nil {
 		return nil, err
 	}
 
-	goidbytes, err := dbp.CurrentThread.readMemory(uintptr(goidaddr), ptrsize)
+	goidbytes, err := thread.readMemory(uintptr(gaddr+uint64(goidoff)), ptrsize)
 	if err != nil {
 		return nil, fmt.Errorf("error reading goid %s", err)
 	}
-	schedbytes, err := dbp.CurrentThread.readMemory(uintptr(schedaddr+uint64(ptrsize)), ptrsize)
+	// The saved stack pointer and program counter are
+	// the first two words of the runtime.gobuf.
+	schedbytes, err := thread.readMemory(uintptr(gaddr+uint64(schedoff)), 2*ptrsize)
 	if err != nil {
 		return nil, fmt.Errorf("error reading sched %s", err)
 	}
-	gopc := binary.LittleEndian.Uint64(schedbytes)
+	gosp := binary.LittleEndian.Uint64(schedbytes)
+	gopc := binary.LittleEndian.Uint64(schedbytes[ptrsize:])
+	var gobp uint64
+	if off, err := dbp.fieldOffset("runtime.gobuf", "bp"); err == nil {
+		bpbytes, err := thread.readMemory(uintptr(gaddr+uint64(schedoff+off)), ptrsize)
+		if err != nil {
+			return nil, fmt.Errorf("error reading sched %s", err)
+		}
+		gobp = binary.LittleEndian.Uint64(bpbytes)
+	}
 	f, l, fn := dbp.goSymTable.PCToLine(gopc)
 	g := &G{
 		Id:   int(binary.LittleEndian.Uint64(goidbytes)),
 		PC:   gopc,
+		SP:   gosp,
+		BP:   gobp,
 		File: f,
 		Line: l,
 		Func: fn,
+		addr: gaddr,
 	}
 	return g, nil
 }
 
+// Returns the offset of the named field within the named struct type.
+// Offsets are looked up once per process.
+func (dbp *DebuggedProcess) fieldOffset(typename, field string) (int64, error) {
+	key := typename + "." + field
+	if off, ok := dbp.fieldOffsets[key]; ok {
+		return off, nil
+	}
+	reader := dbp.dwarf.Reader()
+	entry, err := findDwarfEntry(typename, reader, false)
+	if err != nil {
+		return 0, err
+	}
+	t, err := dbp.dwarf.Type(entry.Offset)
+	if err != nil {
+		return 0, err
+	}
+	st, ok := t.(*dwarf.StructType)
+	if !ok {
+		return 0, fmt.Errorf("%s is not a struct", typename)
+	}
+	for _, f := range st.Field {
+		if f.Name == field {
+			if dbp.fieldOffsets == nil {
+				dbp.fieldOffsets = make(map[string]int64)
+			}
+			dbp.fieldOffsets[key] = f.ByteOffset
+			return f.ByteOffset, nil
+		}
+	}
+	return 0, fmt.Errorf("%s has no field %s", typename, field)
+}
+
+// Like fieldOffset, for runtime structures whose field names changed
+// between Go versions. Returns the offset of the first field found.
+func (dbp *DebuggedProcess) runtimeFieldOffset(typename string, fields ...string) (int64, error) {
+	// Cached under all the names, so that the names missing from this
+	// runtime are not looked up again.
+	key := typename + "." + strings.Join(fields, "|")
+	if off, ok := dbp.fieldOffsets[key]; ok {
+		return off, nil
+	}
+	var err error
+	for _, field := range fields {
+		var off int64
+		if off, err = dbp.fieldOffset(typename, field); err == nil {
+			dbp.fieldOffsets[key] = off
+			return off, nil
+		}
+	}
+	return 0, err
+}
+
 func allglenval(dbp *DebuggedProcess, reader *dwarf.Reader) (uint64, error) {
 	entry, err := findDwarfEntry("runtime.allglen", reader, false)
 	if err != nil {
@@ -286,78 +457,66 @@ func addressFor(dbp *DebuggedProcess, name string, reader *dwarf.Reader) (uint64
 	return uint64(addr), nil
 }
 
-func offsetFor(dbp *DebuggedProcess, name string, reader *dwarf.Reader, parentinstr []byte) (uint64, error) {
-	entry, err := findDwarfEntry(name, reader, true)
+// Returns the value of the named symbol. The symbol may be followed by a
+// chain of members and indexes, such as a.b[2].c, dereferencing pointers
+// between the levels. Symbols are looked up in the scope of the selected
+// frame first, then among package variables, which can be qualified by
+// their package path as in main.config or net/http.DefaultClient.
+func (thread *ThreadContext) EvalSymbol(name string, cfg LoadConfig) (*Variable, error) {
+	expr, err := thread.parseExpr(name)
 	if err != nil {
-		return 0, err
-	}
-	instructions, ok := entry.Val(dwarf.AttrDataMemberLoc).([]byte)
-	if !ok {
-		return 0, fmt.Errorf("type assertion failed")
+		return nil, err
 	}
-	offset, err := op.ExecuteStackProgram(0, append(parentinstr, instructions...))
-	if err != nil {
-		return 0, err
+	if !isSymbolPath(expr) {
+		return nil, fmt.Errorf("%s is not a symbol", name)
 	}
 
-	return uint64(offset), nil
-}
-
-// Returns the value of the named symbol.
-func (thread *ThreadContext) EvalSymbol(name string) (*Variable, error) {
-	pc, err := thread.CurrentPC()
+	// Members, indexes and pointers along the path are resolved by the
+	// expression evaluator, which names the nil segment of a chain.
+	v, err := thread.evalAST(expr)
 	if err != nil {
 		return nil, err
 	}
-
-	reader := thread.Process.DwarfReader()
-
-	_, err = reader.SeekToFunction(pc)
-	if err != nil {
+	if err := thread.loadValue(v, cfg); err != nil {
 		return nil, err
 	}
+	return v, nil
+}
 
-	varName := name
-	memberName := ""
-	if strings.Contains(name, ".") {
-		idx := strings.Index(name, ".")
-		varName = name[:idx]
-		memberName = name[idx+1:]
-	}
-
-	for entry, err := reader.NextScopeVariable(); entry != nil; entry, err = reader.NextScopeVariable() {
-		if err != nil {
-			return nil, err
-		}
-
-		n, ok := entry.Val(dwarf.AttrName).(string)
-		if !ok {
-			continue
-		}
-
-		if n == varName {
-			if len(memberName) == 0 {
-				return thread.extractVariableFromEntry(entry)
-			}
-			return thread.evaluateStructMember(entry, reader, memberName)
+// Returns whether expr is a variable, optionally followed by a chain of
+// member selectors, index expressions and pointer dereferences.
+func isSymbolPath(expr ast.Expr) bool {
+	switch node := expr.(type) {
+	case *ast.Ident:
+		return true
+	case *ast.SelectorExpr:
+		// A quoted package path: "net/http".DefaultClient.
+		if lit, ok := node.X.(*ast.BasicLit); ok {
+			return lit.Kind == token.STRING
 		}
-	}
-
-	return nil, fmt.Errorf("could not find symbol value for %s", name)
+		return isSymbolPath(node.X)
+	case *ast.IndexExpr:
+		return isSymbolPath(node.X)
+	case *ast.StarExpr:
+		return isSymbolPath(node.X)
+	case *ast.ParenExpr:
+		return isSymbolPath(node.X)
+	}
+	return false
 }
 
 // LocalVariables returns all local variables from the current function scope.
-func (thread *ThreadContext) LocalVariables() ([]*Variable, error) {
-	return thread.variablesByTag(dwarf.TagVariable)
+func (thread *ThreadContext) LocalVariables(cfg LoadConfig) ([]*Variable, error) {
+	return thread.variablesByTag(dwarf.TagVariable, cfg)
 }
 
 // FunctionArguments returns the name, value, and type of all current function arguments.
-func (thread *ThreadContext) FunctionArguments() ([]*Variable, error) {
-	return thread.variablesByTag(dwarf.TagFormalParameter)
+func (thread *ThreadContext) FunctionArguments(cfg LoadConfig) ([]*Variable, error) {
+	return thread.variablesByTag(dwarf.TagFormalParameter, cfg)
 }
 
 // PackageVariables returns the name, value, and type of all package variables in the application.
-func (thread *ThreadContext) PackageVariables() ([]*Variable, error) {
+func (thread *ThreadContext) PackageVariables(cfg LoadConfig) ([]*Variable, error) {
 	reader := thread.Process.DwarfReader()
 
 	vars := make([]*Variable, 0)
@@ -368,7 +527,7 @@ func (thread *ThreadContext) PackageVariables() ([]*Variable, error) {
 		}
 
 		// Ignore errors trying to extract values
-		val, err := thread.extractVariableFromEntry(entry)
+		val, err := thread.extractVariableFromEntry(entry, cfg)
 		if err != nil {
 			continue
 		}
@@ -415,74 +574,86 @@ func findDwarfEntry(name string, reader *dwarf.Reader, member bool) (*dwarf.Entr
 	return nil, fmt.Errorf("could not find symbol value for %s", name)
 }
 
-func (thread *ThreadContext) evaluateStructMember(parentEntry *dwarf.Entry, reader *reader.Reader, memberName string) (*Variable, error) {
-	parentAddr, err := thread.extractVariableDataAddress(parentEntry, reader)
-	if err != nil {
-		return nil, err
-	}
-
-	// Get parent variable name
-	parentName, ok := parentEntry.Val(dwarf.AttrName).(string)
-	if !ok {
-		return nil, fmt.Errorf("unable to retrive variable name")
-	}
+// Returns the entries describing the return values of the function
+// containing pc.
+func (thread *ThreadContext) returnValueEntries(pc uint64) ([]*dwarf.Entry, error) {
+	reader := thread.Process.DwarfReader()
 
-	// Seek reader to the type information so members can be iterated
-	_, err = reader.SeekToType(parentEntry, true, true)
+	_, err := reader.SeekToFunction(pc)
 	if err != nil {
 		return nil, err
 	}
 
-	// Iterate to find member by name
-	for memberEntry, err := reader.NextMemberVariable(); memberEntry != nil; memberEntry, err = reader.NextMemberVariable() {
+	entries := make([]*dwarf.Entry, 0)
+	for entry, err := reader.NextScopeVariable(); entry != nil; entry, err = reader.NextScopeVariable() {
 		if err != nil {
 			return nil, err
 		}
-
-		name, ok := memberEntry.Val(dwarf.AttrName).(string)
-		if !ok {
+		if entry.Tag != dwarf.TagFormalParameter {
 			continue
 		}
+		// Output parameters are either flagged as variable parameters
+		// or given a compiler generated name such as ~r0.
+		isret, _ := entry.Val(dwarf.AttrVarParam).(bool)
+		n, _ := entry.Val(dwarf.AttrName).(string)
+		if isret || strings.HasPrefix(n, "~r") {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
 
-		if name == memberName {
-			// Nil ptr, wait until here to throw a nil pointer error to prioritize no such member error
-			if parentAddr == 0 {
-				return nil, fmt.Errorf("%s is nil", parentName)
-			}
-
-			memberInstr, err := instructionsForEntry(memberEntry)
-			if err != nil {
-				return nil, err
-			}
-
-			offset, ok := memberEntry.Val(dwarf.AttrType).(dwarf.Offset)
-			if !ok {
-				return nil, fmt.Errorf("type assertion failed")
-			}
-
-			data := thread.Process.dwarf
-			t, err := data.Type(offset)
-			if err != nil {
-				return nil, err
-			}
+// Reads the return values described by entries from the argument area of
+// a function whose frame had the given CFA.
+func (thread *ThreadContext) returnValues(entries []*dwarf.Entry, cfa int64) ([]*Variable, error) {
+	vars := make([]*Variable, 0, len(entries))
+	for _, entry := range entries {
+		n, ok := entry.Val(dwarf.AttrName).(string)
+		if !ok {
+			return nil, fmt.Errorf("type assertion failed")
+		}
+		offset, ok := entry.Val(dwarf.AttrType).(dwarf.Offset)
+		if !ok {
+			return nil, fmt.Errorf("type assertion failed")
+		}
+		t, err := thread.Process.dwarf.Type(offset)
+		if err != nil {
+			return nil, err
+		}
+		instructions, err := instructionsForEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		addr, err := op.ExecuteStackProgram(cfa, instructions)
+		if err != nil {
+			return nil, err
+		}
+		v := newVariable(n, uintptr(addr), t)
+		if err := thread.loadValue(v, DefaultLoadConfig); err != nil {
+			return nil, err
+		}
+		vars = append(vars, v)
+	}
+	return vars, nil
+}
 
-			baseAddr := make([]byte, 8)
-			binary.LittleEndian.PutUint64(baseAddr, uint64(parentAddr))
+// Extracts the name, type, and value of a variable from a dwarf entry
+func (thread *ThreadContext) extractVariableFromEntry(entry *dwarf.Entry, cfg LoadConfig) (*Variable, error) {
+	v, err := thread.entryVariable(entry)
+	if err != nil {
+		return nil, err
+	}
 
-			parentInstructions := append([]byte{op.DW_OP_addr}, baseAddr...)
-			val, err := thread.extractValue(append(parentInstructions, memberInstr...), 0, t, true)
-			if err != nil {
-				return nil, err
-			}
-			return &Variable{Name: strings.Join([]string{parentName, memberName}, "."), Type: t.String(), Value: val}, nil
-		}
+	if err := thread.loadValue(v, cfg); err != nil {
+		return nil, err
 	}
 
-	return nil, fmt.Errorf("%s has no member %s", parentName, memberName)
+	return v, nil
 }
 
-// Extracts the name, type, and value of a variable from a dwarf entry
-func (thread *ThreadContext) extractVariableFromEntry(entry *dwarf.Entry) (*Variable, error) {
+// Extracts the name, type, and address of a variable from a dwarf entry,
+// without reading its value.
+func (thread *ThreadContext) entryVariable(entry *dwarf.Entry) (*Variable, error) {
 	if entry == nil {
 		return nil, fmt.Errorf("invalid entry")
 	}
@@ -512,389 +683,1119 @@ func (thread *ThreadContext) extractVariableFromEntry(entry *dwarf.Entry) (*Vari
 		return nil, fmt.Errorf("type assertion failed")
 	}
 
-	val, err := thread.extractValue(instructions, 0, t, true)
+	addr, val, err := thread.executeStackProgram(instructions, t.Size())
 	if err != nil {
 		return nil, err
 	}
 
-	return &Variable{Name: n, Type: t.String(), Value: val}, nil
+	v := newVariable(n, uintptr(addr), t)
+	v.data = val
+	return v, nil
 }
 
-// Execute the stack program taking into account the current stack frame
-func (thread *ThreadContext) executeStackProgram(instructions []byte) (int64, error) {
-	regs, err := thread.Registers()
+// Execute the stack program taking into account the selected stack frame,
+// returning the address of the value of `size` bytes it locates. Values
+// that are not stored in memory as a whole, such as those held in
+// registers, are assembled from their pieces and their bytes returned
+// instead.
+func (thread *ThreadContext) executeStackProgram(instructions []byte, size int64) (int64, []byte, error) {
+	frame, err := thread.currentFrame()
 	if err != nil {
-		return 0, err
+		return 0, nil, err
 	}
 
-	fde, err := thread.Process.frameEntries.FDEForPC(regs.PC())
+	// The registers of the thread are those of its innermost frame,
+	// unless a parked goroutine was selected.
+	ctx := &frameContext{thread: thread, frame: frame, innermost: thread.frame == 0 && thread.g == nil}
+	address, pieces, err := op.Execute(ctx, instructions)
 	if err != nil {
-		return 0, err
+		return 0, nil, err
 	}
-
-	fctx := fde.EstablishFrame(regs.PC())
-	cfa := fctx.CFAOffset() + int64(regs.SP())
-	address, err := op.ExecuteStackProgram(cfa, instructions)
-	if err != nil {
-		return 0, err
+	if pieces != nil {
+		data, err := assemblePieces(ctx, pieces, size)
+		return 0, data, err
 	}
-	return address, nil
+	return address, nil, nil
 }
 
-// Extracts the address of a variable, dereferencing any pointers
-func (thread *ThreadContext) extractVariableDataAddress(entry *dwarf.Entry, reader *reader.Reader) (int64, error) {
-	instructions, err := instructionsForEntry(entry)
-	if err != nil {
-		return 0, err
+// Assembles the bytes of a value of `size` bytes from its pieces.
+func assemblePieces(ctx op.Context, pieces []op.Piece, size int64) ([]byte, error) {
+	var data []byte
+	for _, p := range pieces {
+		n := int64(p.Size)
+		if n == 0 {
+			// The piece holds the whole value.
+			n = size
+		}
+		var b []byte
+		switch p.Kind {
+		case op.AddrPiece:
+			var err error
+			if b, err = ctx.ReadMemory(uintptr(p.Addr), int(n)); err != nil {
+				return nil, err
+			}
+		case op.RegPiece:
+			val, err := ctx.Register(p.RegNum)
+			if err != nil {
+				return nil, err
+			}
+			b = make([]byte, 8)
+			binary.LittleEndian.PutUint64(b, val)
+		case op.ImmPiece:
+			b = p.Bytes
+			if b == nil {
+				b = make([]byte, 8)
+				binary.LittleEndian.PutUint64(b, uint64(p.Value))
+			}
+		case op.EmptyPiece:
+			// Optimized away, read as zeros.
+		}
+		if int64(len(b)) > n {
+			b = b[:n]
+		}
+		data = append(data, b...)
+		// Registers and immediate values may be smaller than the piece.
+		for i := int64(len(b)); i < n; i++ {
+			data = append(data, 0)
+		}
 	}
-
-	address, err := thread.executeStackProgram(instructions)
-	if err != nil {
-		return 0, err
+	if int64(len(data)) < size {
+		return nil, fmt.Errorf("pieces of %d bytes for a value of %d bytes", len(data), size)
 	}
+	return data, nil
+}
 
-	// Dereference pointers to get down the concrete type
-	for typeEntry, err := reader.SeekToType(entry, true, false); typeEntry != nil; typeEntry, err = reader.SeekToType(typeEntry, true, false) {
-		if err != nil {
-			return 0, err
-		}
+// The context location expressions are evaluated in: a frame of a
+// thread. Only the stack, frame and instruction pointers of outer frames
+// are known, all registers only for the innermost frame.
+type frameContext struct {
+	thread    *ThreadContext
+	frame     *Stackframe
+	innermost bool
+}
 
-		if typeEntry.Tag != dwarf.TagPointerType {
-			break
-		}
+func (ctx *frameContext) CFA() int64 {
+	return int64(ctx.frame.CFA)
+}
 
-		ptraddress := uintptr(address)
+// Go functions use the CFA as their frame base.
+func (ctx *frameContext) FrameBase() int64 {
+	return int64(ctx.frame.CFA)
+}
 
-		ptr, err := thread.readMemory(ptraddress, ptrsize)
-		if err != nil {
-			return 0, err
+func (ctx *frameContext) Register(n uint64) (uint64, error) {
+	switch n {
+	case dwarfRegSP:
+		return ctx.frame.SP, nil
+	case dwarfRegPC:
+		return ctx.frame.PC, nil
+	case dwarfRegBP:
+		if ctx.frame.BP != 0 {
+			return ctx.frame.BP, nil
 		}
-		address = int64(binary.LittleEndian.Uint64(ptr))
 	}
-
-	return address, nil
+	if !ctx.innermost {
+		return 0, fmt.Errorf("register %d is not available in this frame", n)
+	}
+	regs, err := ctx.thread.Registers()
+	if err != nil {
+		return 0, err
+	}
+	return regs.DwarfRegister(n)
 }
 
-// Extracts the value from the instructions given in the DW_AT_location entry.
-// We execute the stack program described in the DW_OP_* instruction stream, and
-// then grab the value from the other processes memory.
-func (thread *ThreadContext) extractValue(instructions []byte, addr int64, typ interface{}, printStructName bool) (string, error) {
-	return thread.extractValueInternal(instructions, addr, typ, printStructName, 0)
+func (ctx *frameContext) ReadMemory(addr uintptr, size int) ([]byte, error) {
+	return ctx.thread.readMemory(addr, uintptr(size))
 }
 
-func (thread *ThreadContext) extractValueInternal(instructions []byte, addr int64, typ interface{}, printStructName bool, recurseLevel int) (string, error) {
-	var err error
+// Reads the whole string v.
+func (thread *ThreadContext) readString(v *Variable) (string, error) {
+	data, strlen, err := thread.readStringHeader(v)
+	if err != nil {
+		return "", err
+	}
 
-	if addr == 0 {
-		addr, err = thread.executeStackProgram(instructions)
-		if err != nil {
-			return "", err
-		}
+	val, err := thread.readMemory(data, strlen)
+	if err != nil {
+		return "", err
 	}
 
-	// If we have a user defined type, find the
-	// underlying concrete type and use that.
-	for {
-		if tt, ok := typ.(*dwarf.TypedefType); ok {
-			typ = tt.Type
-		} else {
-			break
+	return *(*string)(unsafe.Pointer(&val)), nil
+}
+
+// Returns a variable of type t stored at addr, without loading its value.
+func newVariable(name string, addr uintptr, t dwarf.Type) *Variable {
+	return &Variable{Name: name, Type: t.String(), Addr: addr, dwarfType: t}
+}
+
+// Returns the kind of values of type t.
+func kindOf(t dwarf.Type) reflect.Kind {
+	switch t := resolveTypedef(t).(type) {
+	case *dwarf.PtrType:
+		if _, ok := mapHeader(t); ok {
+			return reflect.Map
+		}
+		if _, ok := chanHeader(t); ok {
+			return reflect.Chan
+		}
+		// unsafe.Pointer does not point to any type.
+		if _, ok := t.Type.(*dwarf.VoidType); ok || t.Type == nil {
+			return reflect.UnsafePointer
+		}
+		return reflect.Ptr
+	case *dwarf.StructType:
+		switch {
+		case t.StructName == "string":
+			return reflect.String
+		case strings.HasPrefix(t.StructName, "[]"):
+			return reflect.Slice
+		case isInterface(t):
+			return reflect.Interface
+		}
+		return reflect.Struct
+	case *dwarf.ArrayType:
+		return reflect.Array
+	case *dwarf.IntType:
+		switch {
+		case t.Name == "int":
+			return reflect.Int
+		case t.ByteSize == 1:
+			return reflect.Int8
+		case t.ByteSize == 2:
+			return reflect.Int16
+		case t.ByteSize == 4:
+			return reflect.Int32
+		}
+		return reflect.Int64
+	case *dwarf.UintType:
+		switch {
+		case t.Name == "uint":
+			return reflect.Uint
+		case t.Name == "uintptr":
+			return reflect.Uintptr
+		case t.ByteSize == 1:
+			return reflect.Uint8
+		case t.ByteSize == 2:
+			return reflect.Uint16
+		case t.ByteSize == 4:
+			return reflect.Uint32
+		}
+		return reflect.Uint64
+	case *dwarf.FloatType:
+		if t.ByteSize == 4 {
+			return reflect.Float32
+		}
+		return reflect.Float64
+	case *dwarf.ComplexType:
+		if t.ByteSize == 8 {
+			return reflect.Complex64
 		}
+		return reflect.Complex128
+	case *dwarf.BoolType:
+		return reflect.Bool
+	case *dwarf.FuncType:
+		return reflect.Func
 	}
+	return reflect.Invalid
+}
+
+// Loads the value of v, which must have its address and type set, and
+// of its children as far as cfg allows.
+func (thread *ThreadContext) loadVariable(v *Variable, recurseLevel int, cfg LoadConfig) error {
+	v.Kind = kindOf(v.dwarfType)
+	v.RealType = resolveTypedef(v.dwarfType)
 
-	ptraddress := uintptr(addr)
-	switch t := typ.(type) {
+	var err error
+	switch t := v.RealType.(type) {
 	case *dwarf.PtrType:
-		ptr, err := thread.readMemory(ptraddress, ptrsize)
-		if err != nil {
-			return "", err
+		switch v.Kind {
+		case reflect.Map:
+			hmap, _ := mapHeader(t)
+			return thread.loadMap(v, hmap, cfg)
+		case reflect.Chan:
+			hchan, _ := chanHeader(t)
+			return thread.loadChan(v, hchan, cfg)
 		}
 
-		intaddr := int64(binary.LittleEndian.Uint64(ptr))
-		if intaddr == 0 {
-			return fmt.Sprintf("%s nil", t.String()), nil
+		ptr, err := thread.readPointer(v)
+		if err != nil {
+			return err
+		}
+		v.ConstValue = constant.MakeUint64(ptr)
+		if ptr == 0 || v.Kind == reflect.UnsafePointer {
+			return nil
+		}
+		if !cfg.FollowPointers {
+			v.Unloaded = true
+			return nil
 		}
 
 		// Don't increase the recursion level when dereferencing pointers
-		val, err := thread.extractValueInternal(nil, intaddr, t.Type, printStructName, recurseLevel)
-		if err != nil {
-			return "", err
-		}
+		child := newVariable("", uintptr(ptr), t.Type)
+		v.Children = []*Variable{child}
+		return thread.loadVariable(child, recurseLevel, cfg)
 
-		return fmt.Sprintf("*%s", val), nil
 	case *dwarf.StructType:
-		switch {
-		case t.StructName == "string":
-			return thread.readString(ptraddress)
-		case strings.HasPrefix(t.StructName, "[]"):
-			return thread.readSlice(ptraddress, t)
-		default:
-			// Recursively call extractValue to grab
-			// the value of all the members of the struct.
-			if recurseLevel <= maxVariableRecurse {
-				fields := make([]string, 0, len(t.Field))
-				for _, field := range t.Field {
-					val, err := thread.extractValueInternal(nil, field.ByteOffset+addr, field.Type, printStructName, recurseLevel+1)
-					if err != nil {
-						return "", err
-					}
-
-					fields = append(fields, fmt.Sprintf("%s: %s", field.Name, val))
-				}
-				if printStructName {
-					return fmt.Sprintf("%s {%s}", t.StructName, strings.Join(fields, ", ")), nil
-				}
-				return fmt.Sprintf("{%s}", strings.Join(fields, ", ")), nil
+		switch v.Kind {
+		case reflect.String:
+			return thread.loadString(v, cfg)
+		case reflect.Slice:
+			var base uintptr
+			var elem dwarf.Type
+			if base, v.Len, v.Cap, elem, err = thread.readSliceHeader(v, t); err != nil {
+				return err
 			}
-			// no fields
-			if printStructName {
-				return fmt.Sprintf("%s {...}", t.StructName), nil
+			return thread.loadElements(v, &Variable{Addr: base}, typeSize(elem), elem, cfg)
+		case reflect.Interface:
+			return thread.loadInterface(v, t, recurseLevel, cfg)
+		}
+
+		// Recursively load the members of the struct.
+		if recurseLevel > cfg.MaxVariableRecurse {
+			v.Unloaded = true
+			return nil
+		}
+		v.Children = make([]*Variable, 0, len(t.Field))
+		for _, field := range t.Field {
+			child := v.part(field.Name, uintptr(field.ByteOffset), field.Type)
+			if err := thread.loadVariable(child, recurseLevel+1, cfg); err != nil {
+				return err
 			}
-			return "{...}", nil
+			v.Children = append(v.Children, child)
 		}
+
 	case *dwarf.ArrayType:
-		return thread.readArray(ptraddress, t)
+		// because you can declare a zero-size array
+		v.Len = t.Count
+		if t.Count > 0 {
+			return thread.loadElements(v, v, t.ByteSize/t.Count, t.Type, cfg)
+		}
+
 	case *dwarf.IntType:
-		return thread.readInt(ptraddress, t.ByteSize)
+		var val []byte
+		if val, err = thread.readVariable(v, 0, uintptr(t.ByteSize)); err == nil {
+			v.ConstValue = constant.MakeInt64(intFromBytes(val))
+		}
 	case *dwarf.UintType:
-		return thread.readUint(ptraddress, t.ByteSize)
+		var val []byte
+		if val, err = thread.readVariable(v, 0, uintptr(t.ByteSize)); err == nil {
+			v.ConstValue = constant.MakeUint64(uintFromBytes(val))
+		}
 	case *dwarf.FloatType:
-		return thread.readFloat(ptraddress, t.ByteSize)
+		var val []byte
+		if val, err = thread.readVariable(v, 0, uintptr(t.ByteSize)); err == nil {
+			var n float64
+			n, err = floatFromBytes(val)
+			v.setFloat(n)
+		}
+	case *dwarf.ComplexType:
+		var val []byte
+		if val, err = thread.readVariable(v, 0, uintptr(t.ByteSize)); err == nil {
+			v.ConstValue, err = complexFromBytes(val)
+		}
 	case *dwarf.BoolType:
-		return thread.readBool(ptraddress)
+		var val []byte
+		if val, err = thread.readVariable(v, 0, 1); err == nil {
+			v.ConstValue = constant.MakeBool(val[0] != 0)
+		}
 	case *dwarf.FuncType:
-		return thread.readFunctionPtr(ptraddress)
-	case *dwarf.VoidType:
-		return "(void)", nil
-	case *dwarf.UnspecifiedType:
-		return "(unknown)", nil
+		return thread.loadFunction(v, recurseLevel, cfg)
+	case *dwarf.VoidType, *dwarf.UnspecifiedType:
 	default:
-		fmt.Printf("Unknown type: %T\n", t)
+		return fmt.Errorf("could not find value for type %s", v.dwarfType)
+	}
+	return err
+}
+
+// Loads v's children from the Len elements of type t stored in arr, the
+// array itself or the backing array of a slice, up to cfg.MaxArrayValues
+// of them.
+func (thread *ThreadContext) loadElements(v *Variable, arr *Variable, stride int64, t dwarf.Type, cfg LoadConfig) error {
+	n := v.Len
+	if n > int64(cfg.MaxArrayValues) {
+		n = int64(cfg.MaxArrayValues)
+	}
+	v.Children = make([]*Variable, 0, n)
+	for i := int64(0); i < n; i++ {
+		child := arr.part("", uintptr(i*stride), t)
+		if err := thread.loadVariable(child, 0, cfg); err != nil {
+			return err
+		}
+		v.Children = append(v.Children, child)
+	}
+	return nil
+}
+
+// Upper bound of the bytes loaded from a string requested whole. The
+// header of a string that is not initialized yet may hold any length.
+const maxStringLoad = 1 << 20
+
+// Loads the string v, up to cfg.MaxStringLen bytes of it.
+func (thread *ThreadContext) loadString(v *Variable, cfg LoadConfig) error {
+	data, strlen, err := thread.readStringHeader(v)
+	if err != nil {
+		return err
 	}
+	v.Len = int64(strlen)
 
-	return "", fmt.Errorf("could not find value for type %s", typ)
+	n := strlen
+	if cfg.MaxStringLen < 0 {
+		if n > maxStringLoad {
+			n = maxStringLoad
+			v.Unloaded = true
+		}
+	} else if n > uintptr(cfg.MaxStringLen) {
+		n = uintptr(cfg.MaxStringLen)
+	}
+	val, err := thread.readMemory(data, n)
+	if err != nil {
+		return err
+	}
+	v.ConstValue = constant.MakeString(string(val))
+	return nil
 }
 
-func (thread *ThreadContext) readString(addr uintptr) (string, error) {
+// Returns the address of the data of the string v and its length.
+func (thread *ThreadContext) readStringHeader(v *Variable) (uintptr, uintptr, error) {
 	// string data structure is always two ptrs in size. Addr, followed by len
 	// http://research.swtch.com/godata
 
 	// read len
-	val, err := thread.readMemory(addr+ptrsize, ptrsize)
+	val, err := thread.readVariable(v, ptrsize, ptrsize)
 	if err != nil {
-		return "", err
+		return 0, 0, err
 	}
 	strlen := uintptr(binary.LittleEndian.Uint64(val))
 
 	// read addr
-	val, err = thread.readMemory(addr, ptrsize)
-	if err != nil {
-		return "", err
-	}
-	addr = uintptr(binary.LittleEndian.Uint64(val))
-
-	val, err = thread.readMemory(addr, strlen)
+	val, err = thread.readVariable(v, 0, ptrsize)
 	if err != nil {
-		return "", err
+		return 0, 0, err
 	}
-
-	return *(*string)(unsafe.Pointer(&val)), nil
+	return uintptr(binary.LittleEndian.Uint64(val)), strlen, nil
 }
 
-func (thread *ThreadContext) readSlice(addr uintptr, t *dwarf.StructType) (string, error) {
+// Reads the header of the slice v of type t, returning the address of
+// its backing array, its length, capacity and element type.
+func (thread *ThreadContext) readSliceHeader(v *Variable, t *dwarf.StructType) (uintptr, int64, int64, dwarf.Type, error) {
 	var sliceLen, sliceCap int64
 	var arrayAddr uintptr
 	var arrayType dwarf.Type
 	for _, f := range t.Field {
 		switch f.Name {
 		case "array":
-			val, err := thread.readMemory(addr+uintptr(f.ByteOffset), ptrsize)
+			val, err := thread.readVariable(v, uintptr(f.ByteOffset), ptrsize)
 			if err != nil {
-				return "", err
+				return 0, 0, 0, nil, err
 			}
 			arrayAddr = uintptr(binary.LittleEndian.Uint64(val))
 			// Dereference array type to get value type
 			ptrType, ok := f.Type.(*dwarf.PtrType)
 			if !ok {
-				return "", fmt.Errorf("Invalid type %s in slice array", f.Type)
+				return 0, 0, 0, nil, fmt.Errorf("Invalid type %s in slice array", f.Type)
 			}
 			arrayType = ptrType.Type
 		case "len":
-			lstr, err := thread.extractValue(nil, int64(addr+uintptr(f.ByteOffset)), f.Type, true)
+			val, err := thread.readVariable(v, uintptr(f.ByteOffset), uintptr(f.Type.Size()))
 			if err != nil {
-				return "", err
-			}
-			sliceLen, err = strconv.ParseInt(lstr, 10, 64)
-			if err != nil {
-				return "", err
+				return 0, 0, 0, nil, err
 			}
+			sliceLen = intFromBytes(val)
 		case "cap":
-			cstr, err := thread.extractValue(nil, int64(addr+uintptr(f.ByteOffset)), f.Type, true)
-			if err != nil {
-				return "", err
-			}
-			sliceCap, err = strconv.ParseInt(cstr, 10, 64)
+			val, err := thread.readVariable(v, uintptr(f.ByteOffset), uintptr(f.Type.Size()))
 			if err != nil {
-				return "", err
+				return 0, 0, 0, nil, err
 			}
+			sliceCap = intFromBytes(val)
 		}
 	}
+	return arrayAddr, sliceLen, sliceCap, arrayType, nil
+}
 
-	stride := arrayType.Size()
-	if _, ok := arrayType.(*dwarf.PtrType); ok {
-		stride = int64(ptrsize)
-	}
-	vals, err := thread.readArrayValues(arrayAddr, sliceLen, stride, arrayType)
-	if err != nil {
-		return "", err
+// Returns the number of bytes a value of type t occupies in an array.
+func typeSize(t dwarf.Type) int64 {
+	if _, ok := t.(*dwarf.PtrType); ok {
+		return int64(ptrsize)
 	}
-
-	return fmt.Sprintf("[]%s len: %d, cap: %d, [%s]", arrayType, sliceLen, sliceCap, strings.Join(vals, ",")), nil
+	return t.Size()
 }
 
-func (thread *ThreadContext) readArray(addr uintptr, t *dwarf.ArrayType) (string, error) {
-	if t.Count > 0 {
-		vals, err := thread.readArrayValues(addr, t.Count, t.ByteSize/t.Count, t.Type)
-		if err != nil {
-			return "", err
+// Returns the underlying type of t, skipping any typedefs.
+func resolveTypedef(t dwarf.Type) dwarf.Type {
+	for {
+		if tt, ok := t.(*dwarf.TypedefType); ok {
+			t = tt.Type
+		} else {
+			return t
 		}
-		return fmt.Sprintf("%s [%s]", t, strings.Join(vals, ",")), nil
 	}
-	// because you can declare a zero-size array
-	return fmt.Sprintf("%s []", t), nil
 }
 
-func (thread *ThreadContext) readArrayValues(addr uintptr, count int64, stride int64, t dwarf.Type) ([]string, error) {
-	vals := make([]string, 0)
+// Set in the kind of runtime types whose values are stored directly in
+// the data word of interfaces.
+const kindDirectIface = 1 << 5
 
-	for i := int64(0); i < count; i++ {
-		// Cap number of elements
-		if i >= maxArrayValues {
-			vals = append(vals, fmt.Sprintf("...+%d more", count-maxArrayValues))
-			break
+// Returns whether t is the runtime representation of an interface,
+// either with methods (iface) or without (eface).
+func isInterface(t *dwarf.StructType) bool {
+	return t.StructName == "runtime.iface" || t.StructName == "runtime.eface"
+}
+
+// Returns the interface structure underlying t, if t is an interface.
+func interfaceHeader(t dwarf.Type) (*dwarf.StructType, bool) {
+	st, ok := resolveTypedef(t).(*dwarf.StructType)
+	if !ok || !isInterface(st) {
+		return nil, false
+	}
+	return st, true
+}
+
+// Returns the dynamic value of the interface x, or nil if the interface
+// is nil. The runtime type of the value is resolved to its DWARF type by
+// name.
+func (thread *ThreadContext) interfaceValue(x *Variable, t *dwarf.StructType) (*Variable, error) {
+	tab, err := structField(t, "tab", "_type")
+	if err != nil {
+		return nil, err
+	}
+	data, err := structField(t, "data")
+	if err != nil {
+		return nil, err
+	}
+
+	tabbytes, err := thread.readVariable(x, uintptr(tab.ByteOffset), ptrsize)
+	if err != nil {
+		return nil, err
+	}
+	typeaddr := binary.LittleEndian.Uint64(tabbytes)
+	if typeaddr == 0 {
+		return nil, nil
+	}
+	if tab.Name == "tab" {
+		off, err := thread.Process.runtimeFieldOffset("runtime.itab", "_type", "type")
+		if err != nil {
+			return nil, err
+		}
+		if typeaddr, err = thread.readUintRaw(uintptr(typeaddr)+uintptr(off), int64(ptrsize)); err != nil {
+			return nil, err
 		}
+	}
 
-		val, err := thread.extractValue(nil, int64(addr+uintptr(i*stride)), t, false)
+	off, err := thread.Process.runtimeFieldOffset("runtime._type", "string", "_string")
+	if err != nil {
+		return nil, err
+	}
+	nameaddr, err := thread.readUintRaw(uintptr(typeaddr)+uintptr(off), int64(ptrsize))
+	if err != nil {
+		return nil, err
+	}
+	typename, err := thread.readString(&Variable{Addr: uintptr(nameaddr)})
+	if err != nil {
+		return nil, err
+	}
+	typ, err := thread.Process.findType(typename)
+	if err != nil {
+		return nil, err
+	}
+
+	off, err = thread.Process.runtimeFieldOffset("runtime._type", "kind")
+	if err != nil {
+		return nil, err
+	}
+	kind, err := thread.readUintRaw(uintptr(typeaddr)+uintptr(off), 1)
+	if err != nil {
+		return nil, err
+	}
+
+	v := x.part("", uintptr(data.ByteOffset), typ)
+	if kind&kindDirectIface == 0 {
+		ptr, err := thread.readPointer(v)
 		if err != nil {
 			return nil, err
 		}
-		vals = append(vals, val)
+		v = newVariable("", uintptr(ptr), typ)
 	}
-	return vals, nil
+	return v, nil
 }
 
-func (thread *ThreadContext) readInt(addr uintptr, size int64) (string, error) {
-	var n int64
+// Loads the dynamic value of the interface v as its only child.
+func (thread *ThreadContext) loadInterface(v *Variable, t *dwarf.StructType, recurseLevel int, cfg LoadConfig) error {
+	child, err := thread.interfaceValue(v, t)
+	if err != nil || child == nil {
+		return err
+	}
+	child.Type = child.dwarfType.String()
+	v.Children = []*Variable{child}
+	return thread.loadVariable(child, recurseLevel+1, cfg)
+}
 
-	val, err := thread.readMemory(addr, uintptr(size))
+// Number of entries in a bucket of a runtime hash map.
+const bucketCnt = 8
+
+// Returns the smallest tophash of a bucket entry in use. Lower values
+// mark empty slots, and slots of old buckets whose entry was evacuated.
+// Go 1.12 added a mark for empty slots, raising it from 4 to 5.
+func (thread *ThreadContext) minTopHash() uint8 {
+	dbp := thread.Process
+	if dbp.minTopHash == 0 {
+		dbp.minTopHash = 5
+		v, err := thread.packageVariable("runtime.buildVersion")
+		if err == nil {
+			version, err := thread.readString(v)
+			if minor, ok := goMinorVersion(version); err == nil && ok && minor < 12 {
+				dbp.minTopHash = 4
+			}
+		}
+	}
+	return dbp.minTopHash
+}
+
+// Returns the minor version of the Go release named by version, such as
+// go1.11.5 or go1.12beta1. Development versions have none.
+func goMinorVersion(version string) (int, bool) {
+	if !strings.HasPrefix(version, "go1.") {
+		return 0, false
+	}
+	version = version[len("go1."):]
+	i := 0
+	for i < len(version) && version[i] >= '0' && version[i] <= '9' {
+		i++
+	}
+	minor, err := strconv.Atoi(version[:i])
+	return minor, err == nil
+}
+
+// Describes the layout of a Go map. DWARF describes maps as a pointer to
+// the hash table header of the runtime, whose buckets field points to an
+// array of buckets holding the keys and values.
+type mapLayout struct {
+	count, b, buckets, oldbuckets int64
+
+	bucketSize                      int64
+	tophash, keys, values, overflow int64
+	keyType, valueType              dwarf.Type
+	keySize, valueSize              int64
+}
+
+// Returns the hash table header t points to, if t is a Go map.
+func mapHeader(t dwarf.Type) (*dwarf.StructType, bool) {
+	ptr, ok := resolveTypedef(t).(*dwarf.PtrType)
+	if !ok {
+		return nil, false
+	}
+	st, ok := resolveTypedef(ptr.Type).(*dwarf.StructType)
+	if !ok || !strings.HasPrefix(st.StructName, "hash<") {
+		return nil, false
+	}
+	return st, true
+}
+
+// Returns the first field of t with one of the given names. Field
+// names of runtime structures vary between Go versions.
+func structField(t *dwarf.StructType, names ...string) (*dwarf.StructField, error) {
+	for _, name := range names {
+		for _, f := range t.Field {
+			if f.Name == name {
+				return f, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%s has no field %s", t.StructName, names[0])
+}
+
+func mapLayoutFor(hmap *dwarf.StructType) (*mapLayout, error) {
+	var l mapLayout
+	for _, f := range []struct {
+		off   *int64
+		names []string
+	}{
+		{&l.count, []string{"count"}},
+		{&l.b, []string{"B"}},
+		{&l.buckets, []string{"buckets"}},
+		{&l.oldbuckets, []string{"oldbuckets"}},
+	} {
+		field, err := structField(hmap, f.names...)
+		if err != nil {
+			return nil, err
+		}
+		*f.off = field.ByteOffset
+	}
+
+	field, _ := structField(hmap, "buckets")
+	ptr, ok := field.Type.(*dwarf.PtrType)
+	if !ok {
+		return nil, fmt.Errorf("invalid buckets type %s", field.Type)
+	}
+	bucket, ok := resolveTypedef(ptr.Type).(*dwarf.StructType)
+	if !ok {
+		return nil, fmt.Errorf("invalid bucket type %s", ptr.Type)
+	}
+	l.bucketSize = bucket.ByteSize
+
+	fields := make([]*dwarf.StructField, 4)
+	for i, names := range [][]string{{"tophash", "topbits"}, {"keys"}, {"values", "elems"}, {"overflow"}} {
+		f, err := structField(bucket, names...)
+		if err != nil {
+			return nil, err
+		}
+		fields[i] = f
+	}
+	l.tophash, l.keys, l.values, l.overflow = fields[0].ByteOffset, fields[1].ByteOffset, fields[2].ByteOffset, fields[3].ByteOffset
+
+	keys, ok := fields[1].Type.(*dwarf.ArrayType)
+	if !ok || keys.Count != bucketCnt {
+		return nil, fmt.Errorf("invalid bucket keys type %s", fields[1].Type)
+	}
+	values, ok := fields[2].Type.(*dwarf.ArrayType)
+	if !ok || values.Count != bucketCnt {
+		return nil, fmt.Errorf("invalid bucket values type %s", fields[2].Type)
+	}
+	l.keyType, l.keySize = keys.Type, keys.ByteSize/bucketCnt
+	l.valueType, l.valueSize = values.Type, values.ByteSize/bucketCnt
+	return &l, nil
+}
+
+// Calls fn with the addresses of the key and the value of every entry of
+// the map whose header is at addr, until fn returns false. Returns the
+// number of entries in the map.
+func (thread *ThreadContext) mapIterate(addr uintptr, l *mapLayout, fn func(key, value uintptr) (bool, error)) (int64, error) {
+	count, err := thread.readIntRaw(addr+uintptr(l.count), int64(ptrsize))
 	if err != nil {
-		return "", err
+		return 0, err
+	}
+	b, err := thread.readUintRaw(addr+uintptr(l.b), 1)
+	if err != nil {
+		return 0, err
+	}
+	buckets, err := thread.readUintRaw(addr+uintptr(l.buckets), int64(ptrsize))
+	if err != nil {
+		return 0, err
+	}
+	oldbuckets, err := thread.readUintRaw(addr+uintptr(l.oldbuckets), int64(ptrsize))
+	if err != nil {
+		return 0, err
 	}
 
-	switch size {
-	case 1:
-		n = int64(val[0])
-	case 2:
-		n = int64(binary.LittleEndian.Uint16(val))
-	case 4:
-		n = int64(binary.LittleEndian.Uint32(val))
-	case 8:
-		n = int64(binary.LittleEndian.Uint64(val))
+	minTopHash := thread.minTopHash()
+
+	// While the map grows, entries that were not yet evacuated are only
+	// found in the old buckets.
+	walk := func(array uintptr, n uint64) (bool, error) {
+		for i := uint64(0); i < n; i++ {
+			for bucket := array + uintptr(i)*uintptr(l.bucketSize); bucket != 0; {
+				tophash, err := thread.readMemory(bucket+uintptr(l.tophash), bucketCnt)
+				if err != nil {
+					return false, err
+				}
+				for j, top := range tophash {
+					if top < minTopHash {
+						continue
+					}
+					key := bucket + uintptr(l.keys+int64(j)*l.keySize)
+					value := bucket + uintptr(l.values+int64(j)*l.valueSize)
+					if cont, err := fn(key, value); !cont || err != nil {
+						return false, err
+					}
+				}
+				overflow, err := thread.readUintRaw(bucket+uintptr(l.overflow), int64(ptrsize))
+				if err != nil {
+					return false, err
+				}
+				bucket = uintptr(overflow)
+			}
+		}
+		return true, nil
 	}
 
-	return strconv.FormatInt(n, 10), nil
+	cont := true
+	if buckets != 0 {
+		if cont, err = walk(uintptr(buckets), 1<<b); err != nil {
+			return 0, err
+		}
+	}
+	if cont && oldbuckets != 0 && b > 0 {
+		if _, err = walk(uintptr(oldbuckets), 1<<(b-1)); err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
 }
 
-func (thread *ThreadContext) readUint(addr uintptr, size int64) (string, error) {
-	var n uint64
+// Loads the entries of the map v, up to cfg.MaxArrayValues of them.
+func (thread *ThreadContext) loadMap(v *Variable, t *dwarf.StructType, cfg LoadConfig) error {
+	l, err := mapLayoutFor(t)
+	if err != nil {
+		return err
+	}
 
-	val, err := thread.readMemory(addr, uintptr(size))
+	hmap, err := thread.readPointer(v)
 	if err != nil {
-		return "", err
+		return err
+	}
+	v.ConstValue = constant.MakeUint64(hmap)
+	if hmap == 0 {
+		return nil
 	}
 
-	switch size {
-	case 1:
-		n = uint64(val[0])
-	case 2:
-		n = uint64(binary.LittleEndian.Uint16(val))
-	case 4:
-		n = uint64(binary.LittleEndian.Uint32(val))
-	case 8:
-		n = uint64(binary.LittleEndian.Uint64(val))
+	v.Children = make([]*Variable, 0)
+	v.Len, err = thread.mapIterate(uintptr(hmap), l, func(k, val uintptr) (bool, error) {
+		// Cap number of elements
+		if len(v.Children) >= 2*cfg.MaxArrayValues {
+			return false, nil
+		}
+		key := newVariable("", k, l.keyType)
+		if err := thread.loadVariable(key, 0, cfg); err != nil {
+			return false, err
+		}
+		value := newVariable("", val, l.valueType)
+		if err := thread.loadVariable(value, 0, cfg); err != nil {
+			return false, err
+		}
+		v.Children = append(v.Children, key, value)
+		return true, nil
+	})
+	return err
+}
+
+// Returns the channel structure t points to, if t is a channel.
+func chanHeader(t dwarf.Type) (*dwarf.StructType, bool) {
+	ptr, ok := resolveTypedef(t).(*dwarf.PtrType)
+	if !ok {
+		return nil, false
 	}
+	st, ok := resolveTypedef(ptr.Type).(*dwarf.StructType)
+	if !ok || !strings.HasPrefix(st.StructName, "hchan<") {
+		return nil, false
+	}
+	return st, true
+}
 
-	return strconv.FormatUint(n, 10), nil
+// Returns the element type of the channel structure t. DWARF only
+// records it in the elem field of the sudogs queued on the channel.
+func chanElemType(t *dwarf.StructType) (dwarf.Type, error) {
+	recvq, err := structField(t, "recvq")
+	if err != nil {
+		return nil, err
+	}
+	waitq, ok := resolveTypedef(recvq.Type).(*dwarf.StructType)
+	if !ok {
+		return nil, fmt.Errorf("invalid recvq type %s", recvq.Type)
+	}
+	first, err := structField(waitq, "first")
+	if err != nil {
+		return nil, err
+	}
+	ptr, ok := first.Type.(*dwarf.PtrType)
+	if !ok {
+		return nil, fmt.Errorf("invalid waitq type %s", first.Type)
+	}
+	sudog, ok := resolveTypedef(ptr.Type).(*dwarf.StructType)
+	if !ok {
+		return nil, fmt.Errorf("invalid sudog type %s", ptr.Type)
+	}
+	elem, err := structField(sudog, "elem")
+	if err != nil {
+		return nil, err
+	}
+	if ptr, ok = elem.Type.(*dwarf.PtrType); !ok {
+		return nil, fmt.Errorf("invalid sudog elem type %s", elem.Type)
+	}
+	return ptr.Type, nil
 }
 
-func (thread *ThreadContext) readFloat(addr uintptr, size int64) (string, error) {
+// Returns the IDs of the goroutines parked in the wait queue at addr.
+func (thread *ThreadContext) waitqGoroutines(addr uintptr) ([]int, error) {
+	next, err := thread.Process.fieldOffset("runtime.sudog", "next")
+	if err != nil {
+		return nil, err
+	}
+	g, err := thread.Process.fieldOffset("runtime.sudog", "g")
+	if err != nil {
+		return nil, err
+	}
+	goid, err := thread.Process.fieldOffset("runtime.g", "goid")
+	if err != nil {
+		return nil, err
+	}
+
+	// The first field of a waitq points to its first sudog.
+	ids := make([]int, 0)
+	sudog, err := thread.readUintRaw(addr, int64(ptrsize))
+	for ; err == nil && sudog != 0; sudog, err = thread.readUintRaw(uintptr(sudog)+uintptr(next), int64(ptrsize)) {
+		gaddr, err := thread.readUintRaw(uintptr(sudog)+uintptr(g), int64(ptrsize))
+		if err != nil {
+			return nil, err
+		}
+		id, err := thread.readIntRaw(uintptr(gaddr)+uintptr(goid), int64(ptrsize))
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, int(id))
+	}
+	return ids, err
+}
+
+// Loads the state of the channel v, and up to cfg.MaxArrayValues of the
+// values buffered in it.
+func (thread *ThreadContext) loadChan(v *Variable, t *dwarf.StructType, cfg LoadConfig) error {
+	elem, err := chanElemType(t)
+	if err != nil {
+		return err
+	}
+
+	hchan, err := thread.readPointer(v)
+	if err != nil {
+		return err
+	}
+	v.ConstValue = constant.MakeUint64(hchan)
+	if hchan == 0 {
+		return nil
+	}
+
+	fields := make(map[string]uint64)
+	for _, f := range []string{"qcount", "dataqsiz", "buf", "closed", "recvx"} {
+		field, err := structField(t, f)
+		if err != nil {
+			return err
+		}
+		val, err := thread.readUintRaw(uintptr(hchan)+uintptr(field.ByteOffset), field.Type.Size())
+		if err != nil {
+			return err
+		}
+		fields[f] = val
+	}
+	v.Len, v.Cap = int64(fields["qcount"]), int64(fields["dataqsiz"])
+
+	// Buffered elements are stored in a ring starting at recvx.
+	buf := &Variable{Name: "buf", Type: fmt.Sprintf("[%d]%s", v.Cap, elem), Kind: reflect.Array, Len: v.Len, Children: make([]*Variable, 0)}
+	stride := typeSize(elem)
+	for i := int64(0); i < v.Len && i < int64(cfg.MaxArrayValues); i++ {
+		idx := (int64(fields["recvx"]) + i) % v.Cap
+		child := newVariable("", uintptr(int64(fields["buf"])+idx*stride), elem)
+		if err := thread.loadVariable(child, 0, cfg); err != nil {
+			return err
+		}
+		buf.Children = append(buf.Children, child)
+	}
+
+	closed := &Variable{Name: "closed", Type: "bool", Kind: reflect.Bool, ConstValue: constant.MakeBool(fields["closed"] != 0)}
+	v.Children = []*Variable{buf, closed}
+
+	for _, q := range []string{"recvq", "sendq"} {
+		field, err := structField(t, q)
+		if err != nil {
+			return err
+		}
+		ids, err := thread.waitqGoroutines(uintptr(hchan) + uintptr(field.ByteOffset))
+		if err != nil {
+			return err
+		}
+		queue := &Variable{Name: q, Type: "[]int", Kind: reflect.Slice, Len: int64(len(ids)), Cap: int64(len(ids))}
+		for _, id := range ids {
+			queue.Children = append(queue.Children, &Variable{Type: "int", Kind: reflect.Int, ConstValue: constant.MakeInt64(int64(id))})
+		}
+		v.Children = append(v.Children, queue)
+	}
+	return nil
+}
+
+func (thread *ThreadContext) readIntRaw(addr uintptr, size int64) (int64, error) {
 	val, err := thread.readMemory(addr, uintptr(size))
 	if err != nil {
-		return "", err
+		return 0, err
 	}
-	buf := bytes.NewBuffer(val)
+	return intFromBytes(val), nil
+}
 
-	switch size {
+func (thread *ThreadContext) readUintRaw(addr uintptr, size int64) (uint64, error) {
+	val, err := thread.readMemory(addr, uintptr(size))
+	if err != nil {
+		return 0, err
+	}
+	return uintFromBytes(val), nil
+}
+
+// Decodes a signed integer of 1, 2, 4 or 8 bytes.
+func intFromBytes(val []byte) int64 {
+	switch len(val) {
+	case 1:
+		return int64(int8(val[0]))
+	case 2:
+		return int64(int16(binary.LittleEndian.Uint16(val)))
 	case 4:
-		n := float32(0)
-		binary.Read(buf, binary.LittleEndian, &n)
-		return strconv.FormatFloat(float64(n), 'f', -1, int(size)*8), nil
+		return int64(int32(binary.LittleEndian.Uint32(val)))
 	case 8:
-		n := float64(0)
-		binary.Read(buf, binary.LittleEndian, &n)
-		return strconv.FormatFloat(n, 'f', -1, int(size)*8), nil
+		return int64(binary.LittleEndian.Uint64(val))
 	}
+	return 0
+}
 
-	return "", fmt.Errorf("could not read float")
+// Decodes an unsigned integer of 1, 2, 4 or 8 bytes.
+func uintFromBytes(val []byte) uint64 {
+	switch len(val) {
+	case 1:
+		return uint64(val[0])
+	case 2:
+		return uint64(binary.LittleEndian.Uint16(val))
+	case 4:
+		return uint64(binary.LittleEndian.Uint32(val))
+	case 8:
+		return binary.LittleEndian.Uint64(val)
+	}
+	return 0
 }
 
-func (thread *ThreadContext) readBool(addr uintptr) (string, error) {
-	val, err := thread.readMemory(addr, uintptr(1))
-	if err != nil {
-		return "", err
+// Decodes a float of 4 or 8 bytes.
+func floatFromBytes(val []byte) (float64, error) {
+	switch len(val) {
+	case 4:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(val))), nil
+	case 8:
+		return math.Float64frombits(binary.LittleEndian.Uint64(val)), nil
 	}
+	return 0, fmt.Errorf("could not read float")
+}
 
-	if val[0] == 0 {
-		return "false", nil
+// Sets the value of a float variable.
+func (v *Variable) setFloat(f float64) {
+	v.ConstValue = constant.MakeFloat64(f)
+	switch {
+	case math.IsNaN(f):
+		v.FloatSpecial = FloatIsNaN
+	case math.IsInf(f, 1):
+		v.FloatSpecial = FloatIsPosInf
+	case math.IsInf(f, -1):
+		v.FloatSpecial = FloatIsNegInf
 	}
+}
 
-	return "true", nil
+// Returns the value of a float variable.
+func (v *Variable) floatValue() float64 {
+	switch v.FloatSpecial {
+	case FloatIsNaN:
+		return math.NaN()
+	case FloatIsPosInf:
+		return math.Inf(1)
+	case FloatIsNegInf:
+		return math.Inf(-1)
+	}
+	f, _ := constant.Float64Val(constant.ToFloat(v.ConstValue))
+	return f
 }
 
-func (thread *ThreadContext) readFunctionPtr(addr uintptr) (string, error) {
-	val, err := thread.readMemory(addr, ptrsize)
+// Decodes a complex number, made of its real and imaginary parts as
+// floats of half its size.
+func complexFromBytes(val []byte) (constant.Value, error) {
+	re, err := floatFromBytes(val[:len(val)/2])
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-
-	// dereference pointer to find function pc
-	addr = uintptr(binary.LittleEndian.Uint64(val))
-	if addr == 0 {
-		return "nil", nil
+	im, err := floatFromBytes(val[len(val)/2:])
+	if err != nil {
+		return nil, err
 	}
+	return constant.BinaryOp(constant.MakeFloat64(re), token.ADD, constant.MakeImag(constant.MakeFloat64(im))), nil
+}
 
-	val, err = thread.readMemory(addr, ptrsize)
+// The DWARF attribute holding the offset of a variable captured by a
+// closure from the start of the closure's context object.
+const attrGoClosureOffset dwarf.Attr = 0x2907
+
+// Loads the func value v: the name and source location of the function
+// it refers to and, for closures, the captured variables as children.
+// A func value points to a context object starting with the entry point
+// of the function and followed by the captured variables, which the
+// DWARF information of the function locates by their offset.
+func (thread *ThreadContext) loadFunction(v *Variable, recurseLevel int, cfg LoadConfig) error {
+	closure, err := thread.readPointer(v)
+	if err != nil || closure == 0 {
+		return err
+	}
+	pc, err := thread.readUintRaw(uintptr(closure), int64(ptrsize))
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	funcAddr := binary.LittleEndian.Uint64(val)
 	reader := thread.Process.DwarfReader()
-
-	entry, err := reader.SeekToFunction(funcAddr)
+	entry, err := reader.SeekToFunction(pc)
 	if err != nil {
-		return "", err
+		return err
 	}
+	name, ok := entry.Val(dwarf.AttrName).(string)
+	if !ok {
+		return fmt.Errorf("Unable to retrieve function name")
+	}
+	v.ConstValue = constant.MakeString(name)
+	v.File, v.Line, _ = thread.Process.goSymTable.PCToLine(pc)
 
-	n, ok := entry.Val(dwarf.AttrName).(string)
+	var captured []*Variable
+	for entry, err := reader.NextScopeVariable(); entry != nil; entry, err = reader.NextScopeVariable() {
+		if err != nil {
+			return err
+		}
+		off, ok := entry.Val(attrGoClosureOffset).(int64)
+		if !ok {
+			continue
+		}
+		cv, err := thread.capturedVariable(entry, uintptr(closure)+uintptr(off))
+		if err != nil {
+			return err
+		}
+		captured = append(captured, cv)
+	}
+	if len(captured) == 0 {
+		return nil
+	}
+
+	if recurseLevel > cfg.MaxVariableRecurse {
+		v.Unloaded = true
+		return nil
+	}
+	for _, cv := range captured {
+		if err := thread.loadVariable(cv, recurseLevel+1, cfg); err != nil {
+			return err
+		}
+	}
+	v.Children = captured
+	return nil
+}
+
+// Returns the variable captured by a closure described by entry, stored
+// at addr in the closure's context object. Variables captured by
+// reference are stored as pointers and named "&<name>"; they are
+// returned dereferenced.
+func (thread *ThreadContext) capturedVariable(entry *dwarf.Entry, addr uintptr) (*Variable, error) {
+	name, ok := entry.Val(dwarf.AttrName).(string)
 	if !ok {
-		return "", fmt.Errorf("Unable to retrieve function name")
+		return nil, fmt.Errorf("type assertion failed")
+	}
+	offset, ok := entry.Val(dwarf.AttrType).(dwarf.Offset)
+	if !ok {
+		return nil, fmt.Errorf("type assertion failed")
+	}
+	t, err := thread.Process.dwarf.Type(offset)
+	if err != nil {
+		return nil, err
 	}
 
-	return n, nil
+	if ptr, ok := resolveTypedef(t).(*dwarf.PtrType); ok && strings.HasPrefix(name, "&") {
+		ref, err := thread.readUintRaw(addr, int64(ptrsize))
+		if err != nil {
+			return nil, err
+		}
+		if ref != 0 {
+			return newVariable(name[1:], uintptr(ref), ptr.Type), nil
+		}
+	}
+	return newVariable(name, addr, t), nil
 }
 
 func (thread *ThreadContext) readMemory(addr uintptr, size uintptr) ([]byte, error) {
+	// Values that are not in memory, such as those assembled from
+	// pieces, have no address.
+	if addr == 0 {
+		return nil, fmt.Errorf("could not read %d bytes at address 0x0", size)
+	}
 	buf := make([]byte, size)
 
 	_, err := readMemory(thread, addr, buf)
@@ -905,33 +1806,66 @@ func (thread *ThreadContext) readMemory(addr uintptr, size uintptr) ([]byte, err
 	return buf, nil
 }
 
-// Fetches all variables of a specific type in the current function scope
-func (thread *ThreadContext) variablesByTag(tag dwarf.Tag) ([]*Variable, error) {
-	pc, err := thread.CurrentPC()
+// Reads size bytes at offset off of the value of v, from the memory of
+// the target or, for values assembled from pieces, from their bytes.
+func (thread *ThreadContext) readVariable(v *Variable, off, size uintptr) ([]byte, error) {
+	if v.data == nil {
+		return thread.readMemory(v.Addr+off, size)
+	}
+	if off+size > uintptr(len(v.data)) {
+		return nil, fmt.Errorf("could not read %d bytes at offset %d of a value of %d bytes", size, off, len(v.data))
+	}
+	return v.data[off : off+size], nil
+}
+
+// Reads the pointer stored in v.
+func (thread *ThreadContext) readPointer(v *Variable) (uint64, error) {
+	val, err := thread.readVariable(v, 0, ptrsize)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
+	return binary.LittleEndian.Uint64(val), nil
+}
 
-	reader := thread.Process.DwarfReader()
+// Returns the part of v of type t at offset off, such as a field or an
+// element. Parts of values assembled from pieces share their bytes.
+func (v *Variable) part(name string, off uintptr, t dwarf.Type) *Variable {
+	if v.data == nil {
+		return newVariable(name, v.Addr+off, t)
+	}
+	p := newVariable(name, 0, t)
+	if off > uintptr(len(v.data)) {
+		off = uintptr(len(v.data))
+	}
+	p.data = v.data[off:len(v.data):len(v.data)]
+	return p
+}
 
-	_, err = reader.SeekToFunction(pc)
+// Fetches all variables of a specific type in the current function scope
+func (thread *ThreadContext) variablesByTag(tag dwarf.Tag, cfg LoadConfig) ([]*Variable, error) {
+	entries, err := thread.scopeEntries()
 	if err != nil {
 		return nil, err
 	}
 
-	vars := make([]*Variable, 0)
-
-	for entry, err := reader.NextScopeVariable(); entry != nil; entry, err = reader.NextScopeVariable() {
-		if err != nil {
-			return nil, err
+	// Depth of the innermost declaration of each name.
+	innermost := make(map[string]int)
+	for _, entry := range entries {
+		if n, ok := entry.Val(dwarf.AttrName).(string); ok && entry.Depth >= innermost[n] {
+			innermost[n] = entry.Depth
 		}
+	}
+
+	vars := make([]*Variable, 0)
 
+	for _, entry := range entries {
 		if entry.Tag == tag {
-			val, err := thread.extractVariableFromEntry(entry)
+			val, err := thread.extractVariableFromEntry(entry.Entry, cfg)
 			if err != nil {
 				// skip variables that we can't parse yet
 				continue
 			}
+			val.Shadowed = entry.Depth < innermost[val.Name]
 
 			vars = append(vars, val)
 		}
@@ -940,6 +1874,52 @@ func (thread *ThreadContext) variablesByTag(tag dwarf.Tag) ([]*Variable, error)
 	return vars, nil
 }
 
+// Returns the entries of the variables and arguments in scope in the
+// selected frame, those of inner lexical blocks after those of the
+// blocks enclosing them.
+//
+// A lexical block covers all of its instructions, including those
+// before the declaration of its variables. Variables declared after the
+// current line are left out, otherwise in
+//
+//	a := 1
+//	{
+//		fmt.Println(a)
+//		a := 2
+//	}
+//
+// the inner a, not yet declared, would shadow the outer one at the
+// Println.
+func (thread *ThreadContext) scopeEntries() ([]reader.Variable, error) {
+	frame, err := thread.currentFrame()
+	if err != nil {
+		return nil, err
+	}
+
+	rdr := thread.Process.DwarfReader()
+
+	fn, err := rdr.SeekToFunction(frame.scopePC)
+	if err != nil {
+		return nil, err
+	}
+	if !fn.Children {
+		return nil, nil
+	}
+
+	vars, err := rdr.ScopeVariables(frame.scopePC)
+	if err != nil {
+		return nil, err
+	}
+	inScope := vars[:0]
+	for _, v := range vars {
+		if line, ok := v.Val(dwarf.AttrDeclLine).(int64); ok && v.Tag == dwarf.TagVariable && int(line) > frame.Line {
+			continue
+		}
+		inScope = append(inScope, v)
+	}
+	return inScope, nil
+}
+
 // Sets the length of a slice.
 func setSliceLength(ptr unsafe.Pointer, l int) {
 	lptr := (*int)(unsafe.Pointer(uintptr(ptr) + ptrsize))
@@ -1,10 +1,16 @@
 package proctl
 
 import (
+	"encoding/binary"
 	"errors"
+	"go/constant"
 	"path/filepath"
+	"reflect"
 	"sort"
+	"strings"
 	"testing"
+
+	"github.com/derekparker/delve/dwarf/op"
 )
 
 type varTest struct {
@@ -66,11 +72,18 @@ func TestVariableEvaluation(t *testing.T) {
 		{"u32", "4294967295", "uint32", nil},
 		{"u64", "18446744073709551615", "uint64", nil},
 		{"u8", "255", "uint8", nil},
-		{"up", "5", "uintptr", nil},
-		{"f", "main.barfoo", "func()", nil},
+		{"up", "0x5", "uintptr", nil},
+		{"f", "main.barfoo " + fp + ":21", "func()", nil},
 		{"ba", "[]int len: 200, cap: 200, [0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,...+136 more]", "struct []int", nil},
 		{"ms", "main.Nest {Level: 0, Nest: *main.Nest {Level: 1, Nest: *main.Nest {...}}}", "main.Nest", nil},
 		{"NonExistent", "", "", errors.New("could not find symbol value for NonExistent")},
+		{"ms.Nest.Nest.Level", "2", "int", nil},
+		{"ms.Nest.Nest.Nest.Nest.Level", "4", "int", nil},
+		{"a13[1].Bur", "g", "struct string", nil},
+		{"a11[2].Baz", "3", "int", nil},
+		{"a12[0]", "main.FooBar {Baz: 4, Bur: d}", "main.FooBar", nil},
+		{"ms.Nest.Nest.Nest.Nest.Nest.Level", "", "", errors.New("ms.Nest.Nest.Nest.Nest.Nest is nil")},
+		{"a2 + 1", "", "", errors.New("a2 + 1 is not a symbol")},
 	}
 
 	withTestProcess(executablePath, t, func(p *DebuggedProcess) {
@@ -83,7 +96,7 @@ func TestVariableEvaluation(t *testing.T) {
 		assertNoError(err, t, "Continue() returned an error")
 
 		for _, tc := range testcases {
-			variable, err := p.EvalSymbol(tc.name)
+			variable, err := p.EvalSymbol(tc.name, DefaultLoadConfig)
 			if tc.err == nil {
 				assertNoError(err, t, "EvalSymbol() returned an error")
 				assertVariable(t, variable, tc)
@@ -96,6 +109,621 @@ func TestVariableEvaluation(t *testing.T) {
 	})
 }
 
+func TestEvalExpression(t *testing.T) {
+	executablePath := "../_fixtures/testvariables"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []varTest{
+		{"a2 + 1", "7", "int", nil},
+		{"(a2 + 1) * 2", "14", "int", nil},
+		{"a2 / 4", "1", "int", nil},
+		{"a2 % 4", "2", "int", nil},
+		{"a3 * 2", "14.46", "float64", nil},
+		{"a2 > 5 && b1", "true", "untyped bool", nil},
+		{"!b1 || a2 != 6", "false", "untyped bool", nil},
+		{"a1 == \"foofoofoofoofoofoo\"", "true", "untyped bool", nil},
+		{"a9 == nil", "true", "untyped bool", nil},
+		{"a4[1]", "2", "int", nil},
+		{"a5[2]", "3", "int", nil},
+		{"a5[1:3]", "[]int len: 2, cap: 4, [2,3]", "struct []int", nil},
+		{"a5[1:3][1]", "3", "int", nil},
+		{"a4[:1]", "[]int len: 1, cap: 2, [1]", "struct []int", nil},
+		{"a1[1:4]", "oof", "struct string", nil},
+		{"a1[0]", "102", "uint8", nil},
+		{"a11[1].Bur", "b", "struct string", nil},
+		{"a12[1].Baz + a13[0].Baz", "11", "int", nil},
+		{"a13[2].Baz", "8", "int", nil},
+		{"*a7", "main.FooBar {Baz: 5, Bur: strum}", "main.FooBar", nil},
+		{"(*a7).Bur", "strum", "struct string", nil},
+		{"&a2", "*6", "*int", nil},
+		{"*(&a2)", "6", "int", nil},
+		{"ms.Nest.Nest.Level", "2", "int", nil},
+		{"float64(a2) / 4", "1.5", "float64", nil},
+		{"int8(u8)", "-1", "int8", nil},
+		{"u8 + 1", "0", "uint8", nil},
+		{"uint8(a2 + 250)", "0", "uint8", nil},
+		{"a9.Baz", "", "", errors.New("a9 is nil")},
+		{"*a9", "", "", errors.New("a9 is nil")},
+		{"a5[10]", "", "", errors.New("index 10 out of range [0:5]")},
+		{"a5[2:1]", "", "", errors.New("slice bounds out of range [2:1:5] with capacity 5")},
+		{"a2 + a3", "", "", errors.New("mismatched types int and float64 in a2 + a3")},
+		{"a2 / 0", "", "", errors.New("division by zero")},
+		{"a2.Baz", "", "", errors.New("a2 (type int) has no members")},
+		{"1i == 1i", "true", "untyped bool", nil},
+		{"1i < 2i", "", "", errors.New("operator < not defined on complex")},
+		{"2 >= 1i", "", "", errors.New("operator >= not defined on complex")},
+	}
+
+	withTestProcess(executablePath, t, func(p *DebuggedProcess) {
+		pc, _, _ := p.goSymTable.LineToPC(fp, 57)
+
+		_, err := p.Break(pc)
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		for _, tc := range testcases {
+			variable, err := p.EvalExpression(tc.name, DefaultLoadConfig)
+			if tc.err == nil {
+				assertNoError(err, t, "EvalExpression() returned an error")
+				assertVariable(t, variable, tc)
+			} else {
+				if err == nil || tc.err.Error() != err.Error() {
+					t.Fatalf("Unexpected error. Expected %s got %v", tc.err.Error(), err)
+				}
+			}
+		}
+	})
+}
+
+func TestMapVariables(t *testing.T) {
+	executablePath := "../_fixtures/testvariables2"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []varTest{
+		{"m1", "map[string]int [one: 1]", "map[string]int", nil},
+		{"m3", "map[string]int nil", "map[string]int", nil},
+		{"m1[\"one\"]", "1", "int", nil},
+		{"m2[42]", "42", "struct string", nil},
+		{"m1[\"two\"]", "", "", errors.New("key \"two\" not found in m1")},
+		{"m3[\"one\"]", "", "", errors.New("key \"one\" not found in m3")},
+	}
+
+	withTestProcess(executablePath, t, func(p *DebuggedProcess) {
+		pc, _, _ := p.goSymTable.LineToPC(fp, 42)
+
+		_, err := p.Break(pc)
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		for _, tc := range testcases {
+			variable, err := p.EvalExpression(tc.name, DefaultLoadConfig)
+			if tc.err == nil {
+				assertNoError(err, t, "EvalExpression() returned an error")
+				assertVariable(t, variable, tc)
+			} else {
+				if err == nil || tc.err.Error() != err.Error() {
+					t.Fatalf("Unexpected error. Expected %s got %v", tc.err.Error(), err)
+				}
+			}
+		}
+
+		// Map iteration order is not deterministic, only check that
+		// the output is capped.
+		variable, err := p.EvalExpression("m2", DefaultLoadConfig)
+		assertNoError(err, t, "EvalExpression() returned an error")
+		if !strings.HasSuffix(variable.Value, ", ...+36 more]") {
+			t.Fatalf("Expected m2 to be capped, got %s", variable.Value)
+		}
+	})
+}
+
+func TestInterfaceVariables(t *testing.T) {
+	executablePath := "../_fixtures/testvariables2"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []varTest{
+		{"err1", "error(*os.PathError) *{Op: open, Path: /nonexistent, Err: error(syscall.Errno) 2}", "error", nil},
+		{"err2", "error nil", "error", nil},
+		{"iface1", "interface {}(int) 5", "interface {}", nil},
+		{"err1.Path", "/nonexistent", "struct string", nil},
+		{"err1.(*os.PathError).Op", "open", "struct string", nil},
+		{"iface1.(int) + 1", "6", "int", nil},
+		{"iface1.(string)", "", "", errors.New("interface conversion: iface1 is int, not string")},
+		{"err2.Op", "", "", errors.New("err2 is nil")},
+	}
+
+	withTestProcess(executablePath, t, func(p *DebuggedProcess) {
+		pc, _, _ := p.goSymTable.LineToPC(fp, 42)
+
+		_, err := p.Break(pc)
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		for _, tc := range testcases {
+			variable, err := p.EvalExpression(tc.name, DefaultLoadConfig)
+			if tc.err == nil {
+				assertNoError(err, t, "EvalExpression() returned an error")
+				assertVariable(t, variable, tc)
+			} else {
+				if err == nil || tc.err.Error() != err.Error() {
+					t.Fatalf("Unexpected error. Expected %s got %v", tc.err.Error(), err)
+				}
+			}
+		}
+	})
+}
+
+func TestChanVariables(t *testing.T) {
+	executablePath := "../_fixtures/testvariables2"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withTestProcess(executablePath, t, func(p *DebuggedProcess) {
+		pc, _, _ := p.goSymTable.LineToPC(fp, 42)
+
+		_, err := p.Break(pc)
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		variable, err := p.EvalSymbol("ch1", DefaultLoadConfig)
+		assertNoError(err, t, "EvalSymbol() returned an error")
+		assertVariable(t, variable, varTest{"ch1", "chan int len: 3, cap: 3, closed: false, [2,3,4], recvq: [], sendq: []", "chan int", nil})
+
+		variable, err = p.EvalSymbol("ch3", DefaultLoadConfig)
+		assertNoError(err, t, "EvalSymbol() returned an error")
+		assertVariable(t, variable, varTest{"ch3", "chan int nil", "chan int", nil})
+
+		// The goroutine sending on ch2 is blocked in its sendq.
+		variable, err = p.EvalSymbol("ch2", DefaultLoadConfig)
+		assertNoError(err, t, "EvalSymbol() returned an error")
+		prefix := "chan string len: 0, cap: 0, closed: false, [], recvq: [], sendq: ["
+		if !strings.HasPrefix(variable.Value, prefix) || strings.HasSuffix(variable.Value, "sendq: []") {
+			t.Fatalf("Expected a goroutine in the sendq of ch2, got %s", variable.Value)
+		}
+	})
+}
+
+func TestBasicTypeVariables(t *testing.T) {
+	executablePath := "../_fixtures/testvariables2"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []varTest{
+		{"c64", "(1+2i)", "complex64", nil},
+		{"c128", "(3.5-4i)", "complex128", nil},
+		{"main.posInf", "+Inf", "float64", nil},
+		{"main.negInf", "-Inf", "float64", nil},
+		{"main.nan", "NaN", "float64", nil},
+		{"main.nan + 1", "", "", errors.New("can not use main.nan in an expression, its value NaN is not finite")},
+		{"r1", "120 'x'", "int32", nil},
+		{"bs", `[]uint8 len: 5, cap: 5, "hello"`, "[]uint8", nil},
+		{"up2", "0xdead", "uintptr", nil},
+		{"c64 * 2", "(2+4i)", "complex64", nil},
+	}
+
+	withTestProcess(executablePath, t, func(p *DebuggedProcess) {
+		pc, _, _ := p.goSymTable.LineToPC(fp, 42)
+
+		_, err := p.Break(pc)
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		for _, tc := range testcases {
+			variable, err := p.EvalExpression(tc.name, DefaultLoadConfig)
+			if tc.err == nil {
+				assertNoError(err, t, "EvalExpression() returned an error")
+				assertVariable(t, variable, tc)
+			} else {
+				if err == nil || tc.err.Error() != err.Error() {
+					t.Fatalf("Unexpected error. Expected %s got %v", tc.err.Error(), err)
+				}
+			}
+		}
+
+		variable, err := p.EvalSymbol("unp", DefaultLoadConfig)
+		assertNoError(err, t, "EvalSymbol() returned an error")
+		if variable.Type != "unsafe.Pointer" || !strings.HasPrefix(variable.Value, "(unsafe.Pointer) 0x") {
+			t.Fatalf("Unexpected value for unp: %s %s", variable.Type, variable.Value)
+		}
+
+		cfg := DefaultLoadConfig
+		cfg.HexDumpBytes = true
+		variable, err = p.EvalSymbol("bs", cfg)
+		assertNoError(err, t, "EvalSymbol() returned an error")
+		expected := "[]uint8 len: 5, cap: 5,\n00000000  68 65 6c 6c 6f                                    |hello|"
+		if variable.Value != expected {
+			t.Fatalf("Expected %q got %q", expected, variable.Value)
+		}
+	})
+}
+
+func TestClosureVariables(t *testing.T) {
+	executablePath := "../_fixtures/testvariables2"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fn1 := "main.main.func2 " + fp + ":39 {count: 0, prefix: mw}"
+	testcases := []varTest{
+		{"fn1", fn1, "func(int) int", nil},
+		{"fn2", "main.main.func3 " + fp + ":40 {fn1: " + fn1 + "}", "func(int) int", nil},
+	}
+
+	withTestProcess(executablePath, t, func(p *DebuggedProcess) {
+		pc, _, _ := p.goSymTable.LineToPC(fp, 42)
+
+		_, err := p.Break(pc)
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		for _, tc := range testcases {
+			variable, err := p.EvalSymbol(tc.name, DefaultLoadConfig)
+			assertNoError(err, t, "EvalSymbol() returned an error")
+			assertVariable(t, variable, tc)
+		}
+
+		cfg := DefaultLoadConfig
+		cfg.MaxVariableRecurse = 0
+		variable, err := p.EvalSymbol("fn2", cfg)
+		assertNoError(err, t, "EvalSymbol() returned an error")
+		assertVariable(t, variable, varTest{"fn2", "main.main.func3 " + fp + ":40 {fn1: main.main.func2 " + fp + ":39 {...}}", "func(int) int", nil})
+	})
+}
+
+func TestFormatModifiers(t *testing.T) {
+	executablePath := "../_fixtures/testvariables"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		format byte
+		varTest
+	}{
+		{'x', varTest{"a6", "main.FooBar {Baz: 0x8, Bur: word}", "main.FooBar", nil}},
+		{'x', varTest{"a5", "[]int len: 5, cap: 5, [0x1,0x2,0x3,0x4,0x5]", "struct []int", nil}},
+		{'x', varTest{"neg", "-0x1", "int", nil}},
+		{'o', varTest{"a2", "06", "int", nil}},
+		{'b', varTest{"u8", "0b11111111", "uint8", nil}},
+		{'c', varTest{"i32", "[2]int32 ['\\x01','\\x02']", "[2]int32", nil}},
+		{'c', varTest{"a2 + 91", "'a'", "int", nil}},
+		{'r', varTest{"u16", "[ff ff]", "uint16", nil}},
+		{'r', varTest{"b1", "[01]", "bool", nil}},
+		{'r', varTest{"a10", "[6f 66 6f]", "struct string", nil}},
+		{'x', varTest{"a3", "7.23", "float64", nil}},
+	}
+
+	withTestProcess(executablePath, t, func(p *DebuggedProcess) {
+		pc, _, _ := p.goSymTable.LineToPC(fp, 57)
+
+		_, err := p.Break(pc)
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		for _, tc := range testcases {
+			cfg := DefaultLoadConfig
+			cfg.Format = tc.format
+			variable, err := p.EvalExpression(tc.name, cfg)
+			assertNoError(err, t, "EvalExpression() returned an error")
+			assertVariable(t, variable, tc.varTest)
+		}
+	})
+}
+
+func TestPackageVariableEvaluation(t *testing.T) {
+	executablePath := "../_fixtures/testvariables2"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []varTest{
+		{"main.config", "main.Config {Name: default, Retries: 3}", "main.Config", nil},
+		{"main.config.Retries", "3", "int", nil},
+		{"config.Name", "default", "struct string", nil},
+		{"main.nonexistent", "", "", errors.New("could not find symbol value for main.nonexistent")},
+	}
+
+	withTestProcess(executablePath, t, func(p *DebuggedProcess) {
+		pc, _, _ := p.goSymTable.LineToPC(fp, 42)
+
+		_, err := p.Break(pc)
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		for _, tc := range testcases {
+			variable, err := p.EvalSymbol(tc.name, DefaultLoadConfig)
+			if tc.err == nil {
+				assertNoError(err, t, "EvalSymbol() returned an error")
+				assertVariable(t, variable, tc)
+			} else if err == nil || tc.err.Error() != err.Error() {
+				t.Fatalf("Unexpected error. Expected %s got %v", tc.err.Error(), err)
+			}
+		}
+
+		variable, err := p.EvalSymbol("io/fs.ErrNotExist", DefaultLoadConfig)
+		assertNoError(err, t, "EvalSymbol() returned an error")
+		if variable.Type != "error" {
+			t.Fatalf("Unexpected type for io/fs.ErrNotExist: %s", variable.Type)
+		}
+	})
+}
+
+func TestQuotePackagePaths(t *testing.T) {
+	packages := map[string]bool{"net/http": true, "github.com/a-b/c.d/e": true}
+	testcases := []struct {
+		expr, quoted string
+	}{
+		{"net/http.DefaultClient", `"net/http".DefaultClient`},
+		{"*net/http.DefaultClient.Transport", `*"net/http".DefaultClient.Transport`},
+		{"github.com/a-b/c.d/e.V.F", `"github.com/a-b/c.d/e".V.F`},
+		{"x / net/http.X[2]+1", `x / "net/http".X[2]+1`},
+		{`"net/http.X" + net/http.Y`, `"net/http.X" + "net/http".Y`},
+		{"a/b.c", "a/b.c"},
+		{"net/http . X", "net/http . X"},
+	}
+	for _, tc := range testcases {
+		if quoted := quotePackagePaths(tc.expr, packages); quoted != tc.quoted {
+			t.Errorf("%s: expected %s got %s", tc.expr, tc.quoted, quoted)
+		}
+	}
+}
+
+func TestGoMinorVersion(t *testing.T) {
+	testcases := []struct {
+		version string
+		minor   int
+		ok      bool
+	}{
+		{"go1.5", 5, true},
+		{"go1.11.5", 11, true},
+		{"go1.12beta1", 12, true},
+		{"go1.21rc2", 21, true},
+		{"devel +8e4e4f5 Tue Oct 16 2018", 0, false},
+		{"go1.", 0, false},
+	}
+	for _, tc := range testcases {
+		if minor, ok := goMinorVersion(tc.version); minor != tc.minor || ok != tc.ok {
+			t.Errorf("%s: expected %d %v got %d %v", tc.version, tc.minor, tc.ok, minor, ok)
+		}
+	}
+}
+
+func TestLexicalScoping(t *testing.T) {
+	executablePath := "../_fixtures/testshadow"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []varTest{
+		{"a", "20", "int", nil},
+		{"b", "inner", "struct string", nil},
+		{"i", "2", "int", nil},
+	}
+
+	withTestProcess(executablePath, t, func(p *DebuggedProcess) {
+		pc, _, _ := p.goSymTable.LineToPC(fp, 12)
+
+		_, err := p.Break(pc)
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		for _, tc := range testcases {
+			variable, err := p.EvalSymbol(tc.name, DefaultLoadConfig)
+			assertNoError(err, t, "EvalSymbol() returned an error")
+			assertVariable(t, variable, tc)
+		}
+
+		vars, err := p.CurrentThread.LocalVariables(DefaultLoadConfig)
+		assertNoError(err, t, "LocalVariables() returned an error")
+		var shadowed []string
+		for _, v := range vars {
+			if v.Shadowed {
+				shadowed = append(shadowed, v.Name+" = "+v.Value)
+			}
+		}
+		sort.Strings(shadowed)
+		if len(vars) != 5 || !reflect.DeepEqual(shadowed, []string{"a = 1", "b = 2"}) {
+			t.Fatalf("Unexpected locals %d, shadowed %v", len(vars), shadowed)
+		}
+	})
+}
+
+func TestSetVariable(t *testing.T) {
+	executablePath := "../_fixtures/testvariables"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		name, value string
+		expected    varTest
+	}{
+		{"a2", "10", varTest{"a2", "10", "int", nil}},
+		{"neg", "-a2", varTest{"neg", "-10", "int", nil}},
+		{"a3", "1.5", varTest{"a3", "1.5", "float64", nil}},
+		{"f32", "2", varTest{"f32", "2", "float32", nil}},
+		{"b1", "false", varTest{"b1", "false", "bool", nil}},
+		{"u8", "7", varTest{"u8", "7", "uint8", nil}},
+		{"a6.Baz", "42", varTest{"a6.Baz", "42", "int", nil}},
+		{"a5[1]", "20", varTest{"a5", "[]int len: 5, cap: 5, [1,20,3,4,5]", "struct []int", nil}},
+		{"a9", "a7", varTest{"a9", "*main.FooBar {Baz: 5, Bur: strum}", "*main.FooBar", nil}},
+		{"a7", "nil", varTest{"a7", "*main.FooBar nil", "*main.FooBar", nil}},
+		{"a1", "baz", varTest{"a1", "bazburzum", "struct string", nil}},
+		{"a6", "a11[2]", varTest{"a6", "main.FooBar {Baz: 3, Bur: c}", "main.FooBar", nil}},
+		{"u8", "256", varTest{err: errors.New("constant 256 overflows uint8")}},
+		{"a2", "1.5", varTest{err: errors.New("can not assign 1.5 to a2 (type int)")}},
+		{"a2", "a3", varTest{err: errors.New("can not assign a3 (type float64) to a2 (type int)")}},
+		{"a2 + 1", "2", varTest{err: errors.New("can not assign to a2 + 1")}},
+	}
+
+	withTestProcess(executablePath, t, func(p *DebuggedProcess) {
+		pc, _, _ := p.goSymTable.LineToPC(fp, 57)
+
+		_, err := p.Break(pc)
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		for _, tc := range testcases {
+			err := p.SetVariable(tc.name, tc.value)
+			if tc.expected.err != nil {
+				if err == nil || tc.expected.err.Error() != err.Error() {
+					t.Fatalf("Unexpected error. Expected %s got %v", tc.expected.err.Error(), err)
+				}
+				continue
+			}
+			assertNoError(err, t, "SetVariable() returned an error")
+
+			variable, err := p.EvalSymbol(tc.expected.name, DefaultLoadConfig)
+			assertNoError(err, t, "EvalSymbol() returned an error")
+			assertVariable(t, variable, tc.expected)
+		}
+	})
+}
+
+func TestLoadConfig(t *testing.T) {
+	executablePath := "../_fixtures/testvariables"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := LoadConfig{MaxVariableRecurse: 0, MaxArrayValues: 3, MaxStringLen: 3, FollowPointers: true}
+	testcases := []varTest{
+		{"a5", "[]int len: 5, cap: 5, [1,2,3,...+2 more]", "struct []int", nil},
+		{"a1", "foo...+15 more", "struct string", nil},
+		{"ba[100:110]", "[]int len: 10, cap: 100, [0,0,0,...+7 more]", "struct []int", nil},
+		{"ms", "main.Nest {Level: 0, Nest: *main.Nest {...}}", "main.Nest", nil},
+	}
+
+	withTestProcess(executablePath, t, func(p *DebuggedProcess) {
+		pc, _, _ := p.goSymTable.LineToPC(fp, 57)
+
+		_, err := p.Break(pc)
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		for _, tc := range testcases {
+			variable, err := p.EvalExpression(tc.name, cfg)
+			assertNoError(err, t, "EvalExpression() returned an error")
+			assertVariable(t, variable, tc)
+		}
+
+		whole := LoadConfig{MaxStringLen: -1}
+		variable, err := p.EvalExpression("a1", whole)
+		assertNoError(err, t, "EvalExpression() returned an error")
+		if variable.Value != "foofoofoofoofoofoo" || variable.Unloaded {
+			t.Fatalf("Expected a1 to be loaded whole, got %s", variable.Value)
+		}
+
+		cfg.FollowPointers = false
+		variable, err = p.EvalSymbol("a7", cfg)
+		assertNoError(err, t, "EvalSymbol() returned an error")
+		if !strings.HasPrefix(variable.Value, "(*main.FooBar) 0x") {
+			t.Fatalf("Expected the address of a7, got %s", variable.Value)
+		}
+	})
+}
+
+func TestVariableTree(t *testing.T) {
+	executablePath := "../_fixtures/testvariables"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withTestProcess(executablePath, t, func(p *DebuggedProcess) {
+		pc, _, _ := p.goSymTable.LineToPC(fp, 57)
+
+		_, err := p.Break(pc)
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		a7, err := p.EvalSymbol("a7", DefaultLoadConfig)
+		assertNoError(err, t, "EvalSymbol() returned an error")
+		if a7.Kind != reflect.Ptr || len(a7.Children) != 1 || a7.Addr == 0 {
+			t.Fatalf("unexpected pointer %#v", a7)
+		}
+		foobar := a7.Children[0]
+		if foobar.Kind != reflect.Struct || len(foobar.Children) != 2 {
+			t.Fatalf("unexpected struct %#v", foobar)
+		}
+		if bur := foobar.Children[1]; bur.Name != "Bur" || bur.Kind != reflect.String || bur.Value != "strum" || bur.Len != 5 {
+			t.Fatalf("unexpected field %#v", bur)
+		}
+
+		ba, err := p.EvalSymbol("ba", DefaultLoadConfig)
+		assertNoError(err, t, "EvalSymbol() returned an error")
+		if ba.Kind != reflect.Slice || ba.Len != 200 || ba.Cap != 200 || len(ba.Children) != DefaultLoadConfig.MaxArrayValues {
+			t.Fatalf("unexpected slice %s len: %d, cap: %d, children: %d", ba.Kind, ba.Len, ba.Cap, len(ba.Children))
+		}
+		if n, _ := constant.Int64Val(ba.Children[0].ConstValue); ba.Children[0].Kind != reflect.Int || n != 0 {
+			t.Fatalf("unexpected element %#v", ba.Children[0])
+		}
+
+		// ms.Nest.Nest is past the recursion limit.
+		ms, err := p.EvalSymbol("ms", DefaultLoadConfig)
+		assertNoError(err, t, "EvalSymbol() returned an error")
+		nest := ms.Children[1].Children[0].Children[1].Children[0]
+		if nest.Kind != reflect.Struct || !nest.Unloaded || len(nest.Children) != 0 {
+			t.Fatalf("unexpected struct %#v", nest)
+		}
+	})
+}
+
 func TestVariableFunctionScoping(t *testing.T) {
 	executablePath := "../_fixtures/testvariables"
 
@@ -113,10 +741,10 @@ func TestVariableFunctionScoping(t *testing.T) {
 		err = p.Continue()
 		assertNoError(err, t, "Continue() returned an error")
 
-		_, err = p.EvalSymbol("a1")
+		_, err = p.EvalSymbol("a1", DefaultLoadConfig)
 		assertNoError(err, t, "Unable to find variable a1")
 
-		_, err = p.EvalSymbol("a2")
+		_, err = p.EvalSymbol("a2", DefaultLoadConfig)
 		assertNoError(err, t, "Unable to find variable a1")
 
 		// Move scopes, a1 exists here by a2 does not
@@ -128,10 +756,10 @@ func TestVariableFunctionScoping(t *testing.T) {
 		err = p.Continue()
 		assertNoError(err, t, "Continue() returned an error")
 
-		_, err = p.EvalSymbol("a1")
+		_, err = p.EvalSymbol("a1", DefaultLoadConfig)
 		assertNoError(err, t, "Unable to find variable a1")
 
-		_, err = p.EvalSymbol("a2")
+		_, err = p.EvalSymbol("a2", DefaultLoadConfig)
 		if err == nil {
 			t.Fatalf("Can eval out of scope variable a2")
 		}
@@ -164,7 +792,7 @@ func TestLocalVariables(t *testing.T) {
 	}
 
 	testcases := []struct {
-		fn     func(*ThreadContext) ([]*Variable, error)
+		fn     func(*ThreadContext, LoadConfig) ([]*Variable, error)
 		output []varTest
 	}{
 		{(*ThreadContext).LocalVariables,
@@ -185,7 +813,7 @@ func TestLocalVariables(t *testing.T) {
 				{"b1", "true", "bool", nil},
 				{"b2", "false", "bool", nil},
 				{"ba", "[]int len: 200, cap: 200, [0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,...+136 more]", "struct []int", nil},
-				{"f", "main.barfoo", "func()", nil},
+				{"f", "main.barfoo " + fp + ":21", "func()", nil},
 				{"f32", "1.2", "float32", nil},
 				{"i32", "[2]int32 [1,2]", "[2]int32", nil},
 				{"i8", "1", "int8", nil},
@@ -195,7 +823,7 @@ func TestLocalVariables(t *testing.T) {
 				{"u32", "4294967295", "uint32", nil},
 				{"u64", "18446744073709551615", "uint64", nil},
 				{"u8", "255", "uint8", nil},
-				{"up", "5", "uintptr", nil}}},
+				{"up", "0x5", "uintptr", nil}}},
 		{(*ThreadContext).FunctionArguments,
 			[]varTest{
 				{"bar", "main.FooBar {Baz: 10, Bur: lorem}", "main.FooBar", nil},
@@ -212,7 +840,7 @@ func TestLocalVariables(t *testing.T) {
 		assertNoError(err, t, "Continue() returned an error")
 
 		for _, tc := range testcases {
-			vars, err := tc.fn(p.CurrentThread)
+			vars, err := tc.fn(p.CurrentThread, DefaultLoadConfig)
 			assertNoError(err, t, "LocalVariables() returned an error")
 
 			sort.Sort(varArray(vars))
@@ -227,3 +855,103 @@ func TestLocalVariables(t *testing.T) {
 		}
 	})
 }
+
+func TestFrameEvaluation(t *testing.T) {
+	executablePath := "../_fixtures/testvariables"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withTestProcess(executablePath, t, func(p *DebuggedProcess) {
+		pc, _, _ := p.goSymTable.LineToPC(fp, 22)
+
+		_, err := p.Break(pc)
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		variable, err := p.EvalSymbol("a1", DefaultLoadConfig)
+		assertNoError(err, t, "EvalSymbol() returned an error")
+		assertVariable(t, variable, varTest{"a1", "bur", "struct string", nil})
+
+		// Move to the caller, foobar, where a1 and a2 are different locals.
+		assertNoError(p.CurrentThread.SelectFrame(1), t, "SelectFrame()")
+
+		variable, err = p.EvalSymbol("a1", DefaultLoadConfig)
+		assertNoError(err, t, "EvalSymbol() returned an error")
+		assertVariable(t, variable, varTest{"a1", "foofoofoofoofoofoo", "struct string", nil})
+
+		variable, err = p.EvalSymbol("a2", DefaultLoadConfig)
+		assertNoError(err, t, "EvalSymbol() returned an error")
+		assertVariable(t, variable, varTest{"a2", "6", "int", nil})
+
+		args, err := p.CurrentThread.FunctionArguments(DefaultLoadConfig)
+		assertNoError(err, t, "FunctionArguments() returned an error")
+		if len(args) != 2 {
+			t.Fatalf("expected 2 arguments in caller frame, got %d", len(args))
+		}
+
+		if err := p.CurrentThread.SelectFrame(1000); err == nil {
+			t.Fatal("expected error selecting nonexistent frame")
+		}
+		if p.CurrentThread.SelectedFrame() != 1 {
+			t.Fatal("failed frame selection changed the selected frame")
+		}
+	})
+}
+
+func TestPieceVariables(t *testing.T) {
+	executablePath := "../_fixtures/testvariables"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withTestProcess(executablePath, t, func(p *DebuggedProcess) {
+		pc, _, _ := p.goSymTable.LineToPC(fp, 57)
+
+		_, err := p.Break(pc)
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		thread := p.CurrentThread
+		regs, err := thread.Registers()
+		assertNoError(err, t, "Registers() returned an error")
+
+		// A value held by a register.
+		typ, err := p.findType("uintptr")
+		assertNoError(err, t, "findType() returned an error")
+		addr, data, err := thread.executeStackProgram([]byte{op.DW_OP_reg0 + dwarfRegSP}, typ.Size())
+		assertNoError(err, t, "executeStackProgram() returned an error")
+		sp := newVariable("sp", uintptr(addr), typ)
+		sp.data = data
+		assertNoError(thread.loadValue(sp, DefaultLoadConfig), t, "loadValue() returned an error")
+		if n, _ := constant.Uint64Val(sp.ConstValue); n != regs.SP() {
+			t.Fatalf("expected %#x got %#x", regs.SP(), n)
+		}
+		if sp.Addr != 0 || sp.inMemory() {
+			t.Fatal("a value held by a register is not in memory")
+		}
+
+		// A string whose data pointer is in memory and whose length is
+		// computed by the expression.
+		a1, err := p.EvalSymbol("a1", DefaultLoadConfig)
+		assertNoError(err, t, "EvalSymbol() returned an error")
+		instructions := []byte{op.DW_OP_addr}
+		instructions = append(instructions, make([]byte, 8)...)
+		binary.LittleEndian.PutUint64(instructions[1:], uint64(a1.Addr))
+		instructions = append(instructions, op.DW_OP_piece, 8, op.DW_OP_lit0+3, op.DW_OP_stack_value, op.DW_OP_piece, 8)
+		addr, data, err = thread.executeStackProgram(instructions, a1.dwarfType.Size())
+		assertNoError(err, t, "executeStackProgram() returned an error")
+		s := newVariable("s", uintptr(addr), a1.dwarfType)
+		s.data = data
+		assertNoError(thread.loadValue(s, DefaultLoadConfig), t, "loadValue() returned an error")
+		assertVariable(t, s, varTest{"s", "foo", "struct string", nil})
+	})
+}
@@ -0,0 +1,107 @@
+package proctl
+
+import (
+	"fmt"
+	"runtime"
+
+	"golang.org/x/arch/x86/x86asm"
+)
+
+// WatchType is the kind of memory access a watchpoint stops on.
+type WatchType uint8
+
+const (
+	WatchWrite WatchType = 1 << iota
+	WatchRead
+	WatchReadWrite = WatchRead | WatchWrite
+)
+
+func (wt WatchType) String() string {
+	switch wt {
+	case WatchWrite:
+		return "watch"
+	case WatchRead:
+		return "rwatch"
+	case WatchReadWrite:
+		return "awatch"
+	}
+	return "break"
+}
+
+// Sets a hardware watchpoint on the memory of the variable denoted by
+// expr, as evaluated in the selected frame of the current thread. Only
+// variables of 1, 2, 4 or 8 bytes can be watched.
+func (dbp *DebuggedProcess) Watch(expr string, wtype WatchType) (*BreakPoint, error) {
+	// TODO(darwin)
+	if runtime.GOOS == "darwin" {
+		return nil, fmt.Errorf("watchpoints are not implemented on darwin")
+	}
+
+	thread := dbp.CurrentThread
+	t, err := thread.parseExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+	// Loading the value would turn it into a constant, check where it
+	// lives first.
+	v, err := thread.evalAST(t)
+	if err != nil {
+		return nil, err
+	}
+	if !v.inMemory() {
+		return nil, fmt.Errorf("can not watch %s, it does not live in memory", expr)
+	}
+	size := v.dwarfType.Size()
+	switch size {
+	case 1, 2, 4, 8:
+	default:
+		return nil, fmt.Errorf("can not watch %s: only values of 1, 2, 4 or 8 bytes can be watched, not %d", expr, size)
+	}
+	if err := thread.loadValue(v, DefaultLoadConfig); err != nil {
+		return nil, err
+	}
+
+	for i, bp := range dbp.HWBreakPoints {
+		if bp != nil {
+			continue
+		}
+		if err := dbp.setHardwareBreakpointAllThreads(i, uint64(v.Addr), wtype, int(size)); err != nil {
+			return nil, fmt.Errorf("could not set watchpoint: %v", err)
+		}
+		bp = dbp.newBreakpoint("", "", 0, uint64(v.Addr), nil)
+		bp.WatchType = wtype
+		bp.WatchExpr = expr
+		bp.watchDwarfType = v.dwarfType
+		bp.OldValue = v.Value
+		bp.NewValue = v.Value
+		dbp.HWBreakPoints[i] = bp
+		return bp, nil
+	}
+	return nil, fmt.Errorf("can not watch %s: all hardware debug registers are in use", expr)
+}
+
+// Records the value of the watched memory, and the instruction that
+// accessed it, after the watchpoint was triggered by thread.
+func (bp *BreakPoint) watchHit(thread *ThreadContext) {
+	bp.OldValue = bp.NewValue
+	v := newVariable("", uintptr(bp.Addr), bp.watchDwarfType)
+	if err := thread.loadValue(v, DefaultLoadConfig); err != nil {
+		v.Value = fmt.Sprintf("<could not read value: %s>", err)
+	}
+	bp.NewValue = v.Value
+
+	bp.TriggerPC, bp.TriggerInst = 0, ""
+	// Without the instruction, a value that did not change is taken
+	// to have been read.
+	bp.triggerRead = bp.OldValue == bp.NewValue
+	pc, err := thread.CurrentPC()
+	if err != nil {
+		return
+	}
+	// Data watchpoints trap once the accessing instruction completed,
+	// so the culprit is the instruction right before the current PC.
+	if addr, inst, err := thread.previousInstruction(pc); err == nil {
+		bp.TriggerPC, bp.TriggerInst = addr, x86asm.GoSyntax(inst, addr, thread.Process.symLookup)
+		bp.triggerRead = readsMemory(inst)
+	}
+}